@@ -5,8 +5,11 @@ go 1.18
 require (
 	github.com/M00NLIG7/go-sigma-rule-engine v0.0.0-20230307200103-5335d57313e3
 	github.com/coreos/go-systemd/v22 v22.5.0
+	github.com/klauspost/compress v1.16.7
 	github.com/olekukonko/tablewriter v0.0.5
 	github.com/schollz/progressbar/v3 v3.13.0
+	github.com/ulikunitz/xz v0.5.11
+	gopkg.in/yaml.v2 v2.4.0
 )
 
 require (
@@ -17,6 +20,5 @@ require (
 	github.com/stretchr/testify v1.6.1 // indirect
 	golang.org/x/sys v0.4.0 // indirect
 	golang.org/x/term v0.4.0 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
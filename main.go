@@ -5,11 +5,19 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"os/user"
+	"strings"
+	"syscall"
+	"time"
 
-	"github.com/M00NLIG7/ChopChopGo/maps/auditd"
-	"github.com/M00NLIG7/ChopChopGo/maps/journald"
-	"github.com/M00NLIG7/ChopChopGo/maps/syslog"
+	"github.com/M00NLIG7/ChopChopGo/maps/chop"
+	"github.com/M00NLIG7/ChopChopGo/maps/rules"
+
+	// Each log source registers itself with chop from its init function.
+	_ "github.com/M00NLIG7/ChopChopGo/maps/auditd"
+	_ "github.com/M00NLIG7/ChopChopGo/maps/journald"
+	_ "github.com/M00NLIG7/ChopChopGo/maps/syslog"
 )
 
 func isRoot() bool {
@@ -20,7 +28,94 @@ func isRoot() bool {
 	return currentUser.Username == "root"
 }
 
+// logsourceTable returns the table selecting rules per target: the built-in
+// one, the one in path, or none when every rule should run everywhere.
+func logsourceTable(path string, all bool) rules.Table {
+	if all {
+		return nil
+	}
+	if path == "" {
+		return rules.DefaultTable
+	}
+	table, err := rules.LoadTable(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	return table
+}
+
+// placeholders loads the -placeholders file, if any.
+func placeholders(path string) map[string][]string {
+	if path == "" {
+		return nil
+	}
+	values, err := rules.LoadPlaceholders(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	return values
+}
+
+// passwd loads the -passwd file, if any.
+// defaultRules is scanned when -rules is not given.
+const defaultRules = "rules/linux/builtin/syslog"
+
+const (
+	rulesUsage       = "Sigma rules to apply: a directory of yaml rules, a single rule file or a glob; repeatable and comma-separated (default " + defaultRules + ")"
+	logsourcesUsage  = "YAML file mapping targets to the Sigma logsources (product, category, service) whose rules they run (default: built-in table for the SigmaHQ linux rules)"
+	allRulesUsage    = "run every rule on every target, ignoring rule logsources"
+	placeholderUsage = "YAML file with the values of the %name% placeholders that rules using the |expand modifier substitute (default: placeholders match anything)"
+)
+
+// validate implements the validate subcommand, which checks a rule
+// directory for CI without scanning anything.
+func validate(args []string) {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: ChopChopGo validate [-target name] [-mapping file] [-strict] -rules dir")
+		fs.PrintDefaults()
+	}
+	target := fs.String("target", "syslog", "target whose fields and mapping the rules are checked against ("+strings.Join(chop.Names(), ", ")+")")
+	var paths chop.StringList
+	fs.Var(&paths, "rules", rulesUsage)
+	mappingPath := fs.String("mapping", "", "path to a custom field-mapping YAML file (overrides the built-in mappings/<target>.yml)")
+	strict := fs.Bool("strict", false, "also fail on unsupported rules and on fields the target cannot provide")
+	logsources := fs.String("logsources", "", logsourcesUsage)
+	allRules := fs.Bool("all-rules", false, allRulesUsage)
+	placeholderPath := fs.String("placeholders", "", placeholderUsage)
+	fs.Parse(args)
+	if len(paths) == 0 {
+		paths = chop.StringList{defaultRules}
+	}
+
+	src, ok := chop.Lookup(*target)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Error: unknown target %q (must be one of %s)\n", *target, strings.Join(chop.Names(), ", "))
+		os.Exit(1)
+	}
+	opts := chop.Options{
+		RulePaths:    paths,
+		MappingPath:  *mappingPath,
+		Logsources:   logsourceTable(*logsources, *allRules),
+		Placeholders: placeholders(*placeholderPath),
+	}
+	passed, err := chop.Validate(os.Stdout, src, opts, *strict)
+	if err != nil {
+		log.Fatalf("validate: %v", err)
+	}
+	if !passed {
+		os.Exit(1)
+	}
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		validate(os.Args[2:])
+		return
+	}
+
 	if !isRoot() {
 		// depending on the file access permissions, we might not need root rights
 		// especially when targeting logs collected from other systems, we might encounter more lax permissions on the files
@@ -28,18 +123,48 @@ func main() {
 	}
 
 	var target string
-	var path string
+	var paths chop.StringList
 	var outputType string
-	var file string
+	var files chop.StringList
 	var mappingPath string
+	var workers int
+	var firstMatch bool
+	var dir string
+	var archive string
+	var tz string
+	var since string
+	var until string
+	var follow bool
+	var statePath string
+	var logsources string
+	var allRules bool
+	var placeholderPath string
 
-	flag.StringVar(&target, "target", "syslog", "what type of data is to be scanned (auditd, journald, syslog)")
-	flag.StringVar(&path, "rules", "rules/linux/builtin/syslog", "where to pull the yaml rules you're applying")
+	flag.StringVar(&target, "target", "syslog", "what type of data is to be scanned ("+strings.Join(chop.Names(), ", ")+")")
+	flag.Var(&paths, "rules", rulesUsage)
 	flag.StringVar(&outputType, "out", "", "what type of output you want (csv, json, or leave empty for table)")
-	flag.StringVar(&file, "file", "", "which specific file should be scanned (falls back to target-specific defaults when left empty)")
+	flag.Var(&files, "file", "file(s) to scan; repeatable, comma-separated and glob patterns accepted (falls back to target-specific defaults when left empty)")
 	flag.StringVar(&mappingPath, "mapping", "", "path to a custom field-mapping YAML file (overrides the built-in mappings/<target>.yml)")
+	flag.StringVar(&dir, "dir", "", "scan every recognised log below this directory (e.g. a collected /var/log), detecting each file's target automatically")
+	flag.StringVar(&archive, "archive", "", "scan every recognised log inside this tar or zip archive (e.g. a UAC triage package), detecting each file's target automatically")
+	flag.BoolVar(&firstMatch, "first-match", false, "report only the first matching rule per event instead of every rule that fired")
+	flag.IntVar(&workers, "workers", 1, "number of goroutines evaluating rules in parallel (results keep log order)")
+	flag.StringVar(&since, "since", "", "only scan events at or after this time: RFC3339 (2024-03-01T08:00:00Z) or a duration ago (24h, 7d)")
+	flag.StringVar(&until, "until", "", "only scan events at or before this time: RFC3339 or a duration ago")
+	flag.BoolVar(&follow, "follow", false, "keep scanning the logs as they grow, like tail -F, printing matches as they occur until interrupted (starts at the end unless -since or -after-cursor is given)")
+	flag.StringVar(&logsources, "logsources", "", logsourcesUsage)
+	flag.BoolVar(&allRules, "all-rules", false, allRulesUsage)
+	flag.StringVar(&placeholderPath, "placeholders", "", placeholderUsage)
+	flag.StringVar(&statePath, "state", "", "checkpoint file: resume each log where the previous run with this file stopped and record the new position, so repeated scans only report new events")
+	flag.StringVar(&tz, "tz", "", "time zone of timestamps without an offset, as an IANA name like Europe/Berlin or UTC (default: local time zone)")
+
+	// Flags such as -unit or -passwd belong to a single source.
+	sourceFlags := chop.RegisterSourceFlags(flag.CommandLine)
 
 	flag.Parse()
+	if len(paths) == 0 {
+		paths = chop.StringList{defaultRules}
+	}
 
 	if outputType != "csv" && outputType != "json" {
 		banner := `  ▄████▄   ██░ ██  ▒█████   ██▓███      ▄████▄   ██░ ██  ▒█████   ██▓███       ▄████  ▒█████
@@ -57,19 +182,111 @@ func main() {
 		fmt.Println(banner)
 	}
 
-	switch target {
-	case "auditd":
-		auditd.ChopToLog(path, outputType, file, mappingPath)
-	case "syslog":
-		syslog.ChopToLog(path, outputType, file, mappingPath)
-	case "journald":
-		if file != "" {
-			fmt.Fprintln(os.Stderr, "Error: the journald target does not support -file; journald uses a binary format accessible only via the systemd API.")
+	if workers < 1 {
+		fmt.Fprintf(os.Stderr, "Error: invalid -workers %d: must be at least 1\n", workers)
+		os.Exit(1)
+	}
+
+	location := time.Local
+	if tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid -tz %q: %v\n", tz, err)
+			os.Exit(1)
+		}
+		location = loc
+	}
+
+	var rng chop.TimeRange
+	now := time.Now()
+	for _, bound := range []struct {
+		flag, value string
+		dest        *time.Time
+	}{{"since", since, &rng.Since}, {"until", until, &rng.Until}} {
+		if bound.value == "" {
+			continue
+		}
+		t, err := chop.ParseTime(bound.value, now)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid -%s: %v\n", bound.flag, err)
 			os.Exit(1)
 		}
-		journald.ChopToLog(path, outputType, mappingPath)
-	default:
-		fmt.Fprintf(os.Stderr, "Error: unknown target %q (must be auditd, journald, or syslog)\n", target)
+		*bound.dest = t
+	}
+	if !rng.Since.IsZero() && !rng.Until.IsZero() && rng.Until.Before(rng.Since) {
+		fmt.Fprintln(os.Stderr, "Error: -until is before -since")
+		os.Exit(1)
+	}
+
+	opts := chop.Options{
+		RulePaths:    paths,
+		Logsources:   logsourceTable(logsources, allRules),
+		Placeholders: placeholders(placeholderPath),
+		OutputType:   outputType,
+		Files:        files,
+		MappingPath:  mappingPath,
+		Workers:      workers,
+		FirstMatch:   firstMatch,
+		Location:     location,
+		Range:        rng,
+		Follow:       follow,
+	}
+	if err := sourceFlags.Apply(flag.CommandLine, target, dir != "" || archive != "", &opts); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
+
+	if dir != "" || archive != "" {
+		if follow || statePath != "" {
+			fmt.Fprintln(os.Stderr, "Error: -follow and -state cannot be combined with -dir or -archive.")
+			os.Exit(1)
+		}
+		root := dir
+		if archive != "" {
+			root = archive
+		}
+		if dir != "" && archive != "" || len(opts.Files) > 0 || mappingPath != "" {
+			fmt.Fprintln(os.Stderr, "Error: -dir and -archive detect each file's target and use its built-in mapping; they cannot be combined with each other, -file or -mapping.")
+			os.Exit(1)
+		}
+		if err := chop.Triage(root, opts); err != nil {
+			log.Fatalf("triage: %v", err)
+		}
+		return
+	}
+
+	src, ok := chop.Lookup(target)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Error: unknown target %q (must be one of %s)\n", target, strings.Join(chop.Names(), ", "))
+		os.Exit(1)
+	}
+	if follow {
+		// Stop on Ctrl-C or SIGTERM, letting the scan finish writing what it
+		// has already read.
+		stop := make(chan struct{})
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-sig
+			close(stop)
+		}()
+		opts.Stop = stop
+	}
+	if statePath != "" {
+		state, err := chop.LoadState(statePath)
+		if err != nil {
+			log.Fatalf("state: %v", err)
+		}
+		opts.State = state
+	}
+	if err := chop.Run(src, opts); err != nil {
+		log.Fatalf("%s: %v", target, err)
+	}
+	if opts.State != nil {
+		// Only a completed scan moves the checkpoints forward, so a failed
+		// run is retried in full next time.
+		if err := opts.State.Save(); err != nil {
+			log.Fatalf("state: %v", err)
+		}
+	}
 }
@@ -2,23 +2,31 @@ package auditd
 
 import (
 	"bufio"
+	"bytes"
+	"flag"
 	"fmt"
-	"log"
+	"io"
 	"os"
 	"strconv"
 	"strings"
 	"time"
 
-	sigma "github.com/M00NLIG7/go-sigma-rule-engine"
-	"github.com/M00NLIG7/ChopChopGo/maps/mapping"
+	"github.com/M00NLIG7/ChopChopGo/maps/chop"
+	"github.com/M00NLIG7/ChopChopGo/maps/input"
 	"github.com/M00NLIG7/ChopChopGo/maps/output"
-	"github.com/schollz/progressbar/v3"
 )
 
 // AuditEvent represents a single record from the auditd log.
 type AuditEvent struct {
 	Type string
 	Data map[string]string
+	// Paths holds the fields of each PATH record of the event in log
+	// order. Data only keeps the first record's name, nametype and mode.
+	Paths []map[string]string
+	// Ancestry and Login are set when events are correlated; see
+	// output.ScanResult.
+	Ancestry []string
+	Login    *output.Login
 }
 
 // Keywords satisfies the sigma.Event interface.
@@ -30,62 +38,65 @@ func (e AuditEvent) Keywords() ([]string, bool) {
 	return keywords, true
 }
 
-// Select satisfies the sigma.Event interface.
+// Select satisfies the sigma.Event interface. PATH[i].field selects a field
+// of the event's i-th PATH record.
 func (e AuditEvent) Select(name string) (interface{}, bool) {
 	if name == "type" {
 		return e.Type, true
 	}
+	if strings.HasPrefix(name, "PATH[") {
+		if value, ok := e.pathField(name); ok {
+			return value, true
+		}
+	}
 	if value, ok := e.Data[name]; ok {
 		return value, true
 	}
 	return nil, false
 }
 
-// MappedAuditEvent wraps an AuditEvent with a field-name mapping so that
-// Sigma rules written with non-native field names (e.g. CommandLine → exe)
-// are resolved transparently before Select is called.
-type MappedAuditEvent struct {
-	AuditEvent
-	m *mapping.Mapping
-}
-
-func (e MappedAuditEvent) Keywords() ([]string, bool) { return e.AuditEvent.Keywords() }
-
-func (e MappedAuditEvent) Select(name string) (interface{}, bool) {
-	return e.AuditEvent.Select(e.m.Resolve(name))
-}
-
 // extractAuditToken finds the audit(UNIXTS.mmm:SEQ) token in line and returns
 // the sequence number and a formatted RFC3339 timestamp. Returns ("", "") when
 // no valid token is present. This replaces the msgRe regex, eliminating the
 // []string submatch allocation on every line.
 func extractAuditToken(line string) (seq, ts string) {
+	seq, at, ok := auditToken(line)
+	if !ok {
+		return seq, ""
+	}
+	return seq, at.Format(time.RFC3339)
+}
+
+// auditToken is extractAuditToken returning the record time unformatted, so
+// that the time-range filter can compare it without reparsing. ok is false
+// when the epoch is missing or malformed.
+func auditToken(line string) (seq string, at time.Time, ok bool) {
 	idx := strings.Index(line, "audit(")
 	if idx < 0 {
-		return "", ""
+		return "", time.Time{}, false
 	}
 	s := line[idx+6:] // skip "audit("
 	dot := strings.IndexByte(s, '.')
 	if dot < 0 {
-		return "", ""
+		return "", time.Time{}, false
 	}
 	unixStr := s[:dot]
 	s = s[dot+1:]
 	colon := strings.IndexByte(s, ':')
 	if colon < 0 {
-		return "", ""
+		return "", time.Time{}, false
 	}
 	s = s[colon+1:]
 	end := strings.IndexByte(s, ')')
 	if end < 0 {
-		return "", ""
+		return "", time.Time{}, false
 	}
 	seq = s[:end]
 	unixTime, err := strconv.ParseInt(unixStr, 10, 64)
 	if err != nil {
-		return seq, ""
+		return seq, time.Time{}, false
 	}
-	return seq, time.Unix(unixTime, 0).UTC().Format(time.RFC3339)
+	return seq, time.Unix(unixTime, 0).UTC(), true
 }
 
 // mergeLineInto parses line with the character-walking tokenizer and writes
@@ -93,6 +104,13 @@ func extractAuditToken(line string) (seq, ts string) {
 // present in dest are not overwritten. The pre-extracted ts and seq are written
 // before scanning so that SYSCALL fields always win over later record types.
 // The msg=audit(...) token is skipped since extractAuditToken already handled it.
+//
+// The fields quoted in a user-space record's msg='...' are merged too, after
+// msg itself.
+//
+// The exceptions are the values auditd hex-encodes: EXECVE arguments, which
+// replace the SYSCALL record's raw a0–a3 registers, are decoded by
+// mergeExecveArg, and proctitle, path and account names by decodeUntrusted.
 func mergeLineInto(line string, dest map[string]string, ts, seq string) {
 	if ts != "" {
 		if _, ok := dest["timestamp"]; !ok {
@@ -105,9 +123,39 @@ func mergeLineInto(line string, dest map[string]string, ts, seq string) {
 		}
 	}
 
+	execve := strings.HasPrefix(line, "type=EXECVE ")
+	var merge func(key, value string, quoted bool)
+	merge = func(key, value string, quoted bool) {
+		// Skip the msg=audit(...) token — handled by extractAuditToken.
+		if key == "msg" && len(value) > 6 && value[:6] == "audit(" {
+			return
+		}
+		if execve && mergeExecveArg(dest, key, value, quoted) {
+			return
+		}
+		if !quoted {
+			value = decodeUntrusted(key, value)
+		}
+		if _, exists := dest[key]; !exists {
+			dest[key] = value
+		}
+		// User-space records (USER_LOGIN, USER_AUTH, …) quote their own
+		// fields as msg='op=login acct="alice" addr=10.0.0.5 …'.
+		if key == "msg" && quoted {
+			scanPairs(value, merge)
+		}
+	}
+	scanPairs(line, merge)
+}
+
+// scanPairs calls fn for each key=value pair of line, with the value
+// unquoted. quoted reports whether it was in double or single quotes, which
+// auditd omits for hex-encoded values. The \x1d that starts the enriched
+// section of a log_format=ENRICHED record separates pairs like a space.
+func scanPairs(line string, fn func(key, value string, quoted bool)) {
 	i, n := 0, len(line)
 	for i < n {
-		for i < n && line[i] == ' ' {
+		for i < n && isSeparator(line[i]) {
 			i++
 		}
 		if i >= n {
@@ -115,11 +163,11 @@ func mergeLineInto(line string, dest map[string]string, ts, seq string) {
 		}
 
 		keyStart := i
-		for i < n && line[i] != '=' && line[i] != ' ' {
+		for i < n && line[i] != '=' && !isSeparator(line[i]) {
 			i++
 		}
 		if i >= n || line[i] != '=' {
-			for i < n && line[i] != ' ' {
+			for i < n && !isSeparator(line[i]) {
 				i++
 			}
 			continue
@@ -128,6 +176,7 @@ func mergeLineInto(line string, dest map[string]string, ts, seq string) {
 		i++ // consume '='
 
 		var value string
+		quoted := i < n && (line[i] == '"' || line[i] == '\'')
 		if i < n && line[i] == '"' {
 			i++
 			start := i
@@ -150,22 +199,24 @@ func mergeLineInto(line string, dest map[string]string, ts, seq string) {
 			}
 		} else {
 			start := i
-			for i < n && line[i] != ' ' {
+			for i < n && !isSeparator(line[i]) {
 				i++
 			}
 			value = line[start:i]
 		}
-
-		// Skip the msg=audit(...) token — handled by extractAuditToken.
-		if key == "msg" && len(value) > 6 && value[:6] == "audit(" {
-			continue
-		}
-		if _, exists := dest[key]; !exists {
-			dest[key] = value
-		}
+		fn(key, value, quoted)
 	}
 }
 
+// isSeparator reports whether c separates key=value pairs.
+func isSeparator(c byte) bool {
+	return c == ' ' || c == enrichedSeparator
+}
+
+// enrichedSeparator precedes the interpreted fields auditd appends to each
+// record when log_format=ENRICHED.
+const enrichedSeparator = '\x1d'
+
 // parseLine tokenizes a single auditd log line into a key-value map.
 // The hot path in ParseEvents calls extractAuditToken + mergeLineInto directly
 // to avoid allocating an intermediate map; parseLine is retained for tests.
@@ -184,6 +235,26 @@ const windowSize = 32
 
 // ParseEvents reads an auditd log file, correlates multi-record events by their
 // sequence number, and returns one merged AuditEvent per logical event.
+// It is a convenience wrapper around StreamEvents for callers that want the
+// whole log in memory; Source.Stream, which chop.Run scans with, streams
+// instead.
+func ParseEvents(logFile string) ([]AuditEvent, error) {
+	var events []AuditEvent
+	err := StreamEvents(logFile, nil, chop.TimeRange{}, func(e AuditEvent) error {
+		events = append(events, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// StreamEvents reads an auditd log file, correlates multi-record events by
+// their sequence number, and calls fn once per merged AuditEvent in log order.
+// A non-nil error from fn stops the scan and is returned unchanged.
+// Rotated logs compressed with gzip, bzip2, xz or zstd are decompressed on
+// the fly; see input.Open.
 //
 // auditd writes several record types (SYSCALL, EXECVE, CWD, PATH, …) for a
 // single kernel event, all sharing the same msg=audit(ts:seq) sequence number.
@@ -196,30 +267,60 @@ const windowSize = 32
 //
 // Streaming sliding-window: at most windowSize groups are kept in memory at
 // once. When the window is full and a new sequence number arrives, the oldest
-// group is flushed immediately. This reduces peak memory from O(total records)
-// to O(windowSize × fields), making multi-GB log scanning practical.
-func ParseEvents(logFile string) ([]AuditEvent, error) {
-	file, err := os.Open(logFile)
+// group is handed to fn immediately. Peak memory is O(windowSize × fields)
+// regardless of log size, making multi-GB log scanning practical.
+//
+// Records whose audit(...) time falls outside rng are dropped before they are
+// grouped, so out-of-range events cost only the token scan.
+//
+// Each event gets the interpreted fields of an ENRICHED log (SYSCALL=execve,
+// AUID=alice, …) even when the log was written without them; uids are named
+// from users, a table read by LoadPasswd, which may be nil.
+func StreamEvents(logFile string, users map[string]string, rng chop.TimeRange, fn func(AuditEvent) error) error {
+	file, err := input.Open(logFile)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer file.Close()
+	return StreamReader(file, users, rng, fn)
+}
 
-	var events []AuditEvent
+// StreamReader is StreamEvents for an already-open, decompressed log stream,
+// such as a member of a tar archive. When r is an *input.Follower the window
+// is flushed each time the follower catches up with the log, so a followed
+// event is reported once auditd has written all of its records rather than
+// when windowSize later events have arrived.
+func StreamReader(r io.Reader, users map[string]string, rng chop.TimeRange, fn func(AuditEvent) error) error {
 	standalone := 0
 
 	// window is a fixed-capacity queue of seq strings in insertion order.
 	// We keep it at most windowSize long; copy+reslice keeps the backing
 	// array capped at windowSize+1 so memory stays O(windowSize).
 	window := make([]string, 0, windowSize+1)
-	// groups maps seq → merged field map; only window entries are present.
-	groups := make(map[string]map[string]string, windowSize)
+	// groups maps seq → merged records; only window entries are present.
+	groups := make(map[string]*recordGroup, windowSize)
 
 	// soloKey is a stack-allocated scratch buffer for formatting __solo_N keys,
 	// avoiding the interface boxing that fmt.Sprintf would cause.
 	var soloKey [32]byte
 
-	scanner := bufio.NewScanner(file)
+	// flush hands every buffered group to fn in insertion order.
+	flush := func() error {
+		for _, seq := range window {
+			g := groups[seq]
+			delete(groups, seq)
+			if err := fn(g.event(users)); err != nil {
+				return err
+			}
+		}
+		window = window[:0]
+		return nil
+	}
+	if fl, ok := r.(*input.Follower); ok {
+		fl.Idle = flush
+	}
+
+	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		line := scanner.Text()
 		if !strings.HasPrefix(line, "type=") {
@@ -227,7 +328,14 @@ func ParseEvents(logFile string) ([]AuditEvent, error) {
 		}
 
 		// Extract seq and timestamp without allocating an intermediate map.
-		seq, ts := extractAuditToken(line)
+		seq, at, timed := auditToken(line)
+		if timed && !rng.Contains(at) {
+			continue
+		}
+		var ts string
+		if timed {
+			ts = at.Format(time.RFC3339)
+		}
 		if seq == "" {
 			// Record has no parseable sequence — treat as its own event.
 			b := append(soloKey[:0], "__solo_"...)
@@ -244,25 +352,50 @@ func ParseEvents(logFile string) ([]AuditEvent, error) {
 				window = window[:len(window)-1]
 				g := groups[oldest]
 				delete(groups, oldest)
-				events = append(events, AuditEvent{Type: g["type"], Data: g})
+				if err := fn(g.event(users)); err != nil {
+					return err
+				}
 			}
 			window = append(window, seq)
-			groups[seq] = make(map[string]string, 16)
+			groups[seq] = &recordGroup{data: make(map[string]string, 16)}
 		}
 
 		// Merge directly into the group map — no intermediate map allocated.
-		mergeLineInto(line, groups[seq], ts, seq)
+		g := groups[seq]
+		mergeLineInto(line, g.data, ts, seq)
+		if strings.HasPrefix(line, "type=PATH ") {
+			g.paths = append(g.paths, parsePath(line))
+		}
 	}
 	if err := scanner.Err(); err != nil {
-		return nil, err
+		return err
 	}
 
-	// Flush all remaining groups in insertion order.
-	for _, seq := range window {
-		g := groups[seq]
-		events = append(events, AuditEvent{Type: g["type"], Data: g})
+	return flush()
+}
+
+// detectLines is how many non-empty lines Detect inspects before giving up.
+const detectLines = 5
+
+// Detect reports whether head, the start of a decompressed log, looks like
+// raw auditd output. It reuses extractAuditToken so classification agrees
+// with what StreamEvents can actually correlate.
+func Detect(head []byte) bool {
+	checked := 0
+	for _, line := range bytes.Split(head, []byte{'\n'}) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		if bytes.HasPrefix(line, []byte("type=")) {
+			if seq, _ := extractAuditToken(string(line)); seq != "" {
+				return true
+			}
+		}
+		if checked++; checked >= detectLines {
+			break
+		}
 	}
-	return events, nil
+	return false
 }
 
 // FindLog returns filePath when non-empty, otherwise reads /etc/audit/auditd.conf
@@ -295,7 +428,7 @@ func FindLog(file string) (string, error) {
 }
 
 var auditdRenderer = output.Renderer{
-	Headers: []string{"Timestamp", "User", "Exe", "Terminal", "PID", "Tags", "Author"},
+	Headers: []string{"Timestamp", "User", "Exe", "Terminal", "PID", "Title", "Tags", "Author"},
 	Row: func(r output.ScanResult) []string {
 		return []string{
 			r.Timestamp,
@@ -303,79 +436,167 @@ var auditdRenderer = output.Renderer{
 			r.Exe,
 			r.Terminal,
 			r.PID,
+			r.Title,
 			output.TagString(r.Tags),
 			r.Author,
 		}
 	},
 }
 
-func toScanResult(event AuditEvent, res sigma.Results) output.ScanResult {
+// Result satisfies the chop.Event interface.
+func (e AuditEvent) Result() output.ScanResult {
 	return output.ScanResult{
-		Timestamp: event.Data["timestamp"],
-		// auditd logs use lowercase "auid", not "AUID"
-		User:     event.Data["auid"],
-		Exe:      event.Data["exe"],
-		Terminal: event.Data["terminal"],
-		PID:      event.Data["pid"],
-		Tags:     res[0].Tags,
-		Author:   res[0].Author,
-		RuleID:   res[0].ID,
-		Title:    res[0].Title,
+		Timestamp: e.Data["timestamp"],
+		User:      e.user(),
+		Exe:       e.Data["exe"],
+		Terminal:  e.Data["terminal"],
+		PID:       e.Data["pid"],
+		Ancestry:  e.Ancestry,
+		Login:     e.Login,
 	}
 }
 
-// Chop scans the auditd log against Sigma rules and writes results to stdout.
-// mappingPath overrides the default mappings/auditd.yml when non-empty.
-func Chop(rulePath, outputType, filePath, mappingPath string) error {
-	auditdLogPath, err := FindLog(filePath)
-	if err != nil {
-		return fmt.Errorf("finding audit log: %w", err)
+// user returns the login user: the interpreted AUID, or the raw auid of an
+// event built without interpretation.
+func (e AuditEvent) user() string {
+	if name, ok := e.Data["AUID"]; ok {
+		return name
 	}
+	return e.Data["auid"]
+}
 
-	events, err := ParseEvents(auditdLogPath)
-	if err != nil {
-		return fmt.Errorf("parsing audit log: %w", err)
-	}
+// Source plugs auditd logs into the chop registry under the "auditd" target.
+type Source struct{}
+
+func init() { chop.Register(Source{}) }
+
+// Name satisfies the chop.Source interface.
+func (Source) Name() string { return "auditd" }
 
-	ruleset, err := sigma.NewRuleset(sigma.Config{Directory: []string{rulePath}})
+// FindLog satisfies the chop.Source interface.
+func (Source) FindLog(file string) (string, error) { return FindLog(file) }
+
+// Stream satisfies the chop.Source interface.
+// The log is opened with chop.OpenLog, so -follow and -state apply.
+func (Source) Stream(path string, opts chop.Options, emit func(chop.Event) error) error {
+	r, err := chop.OpenLog(path, opts)
 	if err != nil {
-		return fmt.Errorf("loading ruleset: %w", err)
+		return err
 	}
+	defer r.Close()
+	return StreamReader(r, config(opts).Users, opts.Range, emitter(opts, emit))
+}
 
-	showProgress := outputType != "json" && outputType != "csv"
-	var bar *progressbar.ProgressBar
-	if showProgress {
-		bar = progressbar.Default(int64(len(events)))
+// StreamReader satisfies the chop.Detector interface.
+func (Source) StreamReader(r io.Reader, modTime time.Time, opts chop.Options, emit func(chop.Event) error) error {
+	return StreamReader(r, config(opts).Users, opts.Range, emitter(opts, emit))
+}
+
+// Config holds the auditd settings given on the command line.
+type Config struct {
+	// Users maps uids to account names, as read from -passwd, for the
+	// fields interpreted from a uid. Nil only names root.
+	Users map[string]string
+	// Correlate links events by ses, pid/ppid and auid into login sessions
+	// and process trees, adding each result's process ancestry and session
+	// login.
+	Correlate bool
+}
+
+// config returns the auditd settings recorded in opts.
+func config(opts chop.Options) Config {
+	cfg, _ := opts.Setting("auditd").(Config)
+	return cfg
+}
+
+// Flags satisfies the chop.Flagger interface.
+func (Source) Flags(fs *flag.FlagSet) func(*chop.Options, bool) error {
+	var cfg Config
+	var passwd string
+	fs.StringVar(&passwd, "passwd", "", "auditd only: passwd file of the host that wrote the logs, naming the uids in interpreted fields such as AUID (default: only root is named)")
+	fs.BoolVar(&cfg.Correlate, "correlate", false, "auditd only: link events by ses, pid/ppid and auid into login sessions and process trees, adding each match's process ancestry (sshd → bash → curl) and the login that opened its session")
+	return func(opts *chop.Options, triage bool) error {
+		if passwd != "" {
+			users, err := LoadPasswd(passwd)
+			if err != nil {
+				return err
+			}
+			cfg.Users = users
+		}
+		opts.SetSetting("auditd", cfg)
+		opts.Context = opts.Context || cfg.Correlate
+		return nil
 	}
+}
 
-	if mappingPath == "" {
-		mappingPath = "mappings/auditd.yml"
+// emitter returns the callback handing a log's events to emit, correlating
+// them first when -correlate is set.
+func emitter(opts chop.Options, emit func(chop.Event) error) func(AuditEvent) error {
+	if !config(opts).Correlate {
+		return func(e AuditEvent) error { return emit(e) }
+	}
+	c := newCorrelator()
+	return func(e AuditEvent) error {
+		c.observe(&e)
+		return emit(e)
 	}
-	m := mapping.LoadOrIdentity(mappingPath, "auditd")
+}
 
-	var results []output.ScanResult
-	for _, event := range events {
-		mapped := MappedAuditEvent{event, m}
-		if res, match := ruleset.EvalAll(mapped); match {
-			results = append(results, toScanResult(event, res))
-		}
-		if showProgress {
-			bar.Add(1)
-		}
+// Detect satisfies the chop.Detector interface.
+func (Source) Detect(head []byte) bool { return Detect(head) }
+
+// Fields satisfies the chop.Source interface. auditd records carry arbitrary
+// key=value pairs, so this lists the fields present on common record types
+// rather than an exhaustive set.
+func (Source) Fields() []string {
+	return []string{
+		"type", "timestamp", "seq", "arch", "syscall", "success", "exit",
+		"a0", "a1", "a2", "a3", "argc", "items", "ppid", "pid", "auid", "uid",
+		"gid", "euid", "suid", "fsuid", "egid", "sgid", "fsgid", "tty", "ses",
+		"comm", "exe", "key", "cwd", "name", "nametype", "mode", "inode",
+		"ouid", "ogid", "acct", "hostname", "addr", "terminal", "res", "op",
+		"proctitle", "CommandLine", "ARCH", "SYSCALL", "AUID", "UID", "EUID",
+		"SUID", "FSUID", "OUID", "EXIT", "RES",
 	}
+}
 
-	if err := output.Write(os.Stdout, outputType, results, auditdRenderer); err != nil {
-		return fmt.Errorf("writing output: %w", err)
+// HasField satisfies the chop.OpenFields interface. Any name in auditd's
+// key syntax is accepted: lowercase keys such as a4 or old-auid, the
+// uppercase fields of the ENRICHED format, split arguments like a1[0] and
+// PATH[i].field selectors.
+func (Source) HasField(name string) bool {
+	if strings.HasPrefix(name, "PATH[") {
+		index, field, ok := strings.Cut(name[len("PATH["):], "].")
+		if _, err := strconv.Atoi(index); !ok || err != nil {
+			return false
+		}
+		return isKey(field, 'a', 'z')
 	}
-	if showProgress {
-		fmt.Printf("Processed %d auditd events\n", len(events))
+	if arg, chunk, ok := strings.Cut(name, "["); ok {
+		_, isArg := argIndex(arg)
+		_, err := strconv.Atoi(strings.TrimSuffix(chunk, "]"))
+		return isArg && strings.HasSuffix(chunk, "]") && err == nil
 	}
-	return nil
+	return isKey(name, 'a', 'z') || isKey(name, 'A', 'Z')
 }
 
-// ChopToLog is like Chop but calls log.Fatalf on error, for use from main.
-func ChopToLog(rulePath, outputType, filePath, mappingPath string) {
-	if err := Chop(rulePath, outputType, filePath, mappingPath); err != nil {
-		log.Fatalf("auditd: %v", err)
+// isKey reports whether name is an auditd key whose letters are all between
+// lo and hi: a letter followed by letters, digits, _ and -.
+func isKey(name string, lo, hi byte) bool {
+	if name == "" || name[0] < lo || name[0] > hi {
+		return false
+	}
+	for i := 1; i < len(name); i++ {
+		c := name[i]
+		if !(c >= lo && c <= hi || c >= '0' && c <= '9' || c == '_' || c == '-') {
+			return false
+		}
 	}
+	return true
 }
+
+// Renderer satisfies the chop.Source interface.
+func (Source) Renderer() output.Renderer { return auditdRenderer }
+
+// DefaultMapping satisfies the chop.Source interface.
+func (Source) DefaultMapping() string { return "mappings/auditd.yml" }
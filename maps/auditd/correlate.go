@@ -0,0 +1,124 @@
+package auditd
+
+import (
+	"path"
+
+	"github.com/M00NLIG7/ChopChopGo/maps/output"
+)
+
+// maxAncestry bounds the process chains a correlator builds, so that a
+// parent loop caused by pid reuse cannot run away.
+const maxAncestry = 32
+
+// unsetID is how auditd logs the auid and ses of a process no user logged in
+// to.
+const unsetID = "4294967295"
+
+// correlator links the events of one log into process trees and login
+// sessions. It sees every event in log order, so an event is described by
+// what the log has shown up to it: the latest image of each pid, and the
+// latest login of each session.
+type correlator struct {
+	procs    map[string]process
+	sessions map[string]*output.Login
+	// auids holds the auid of each session's login, which tells a session
+	// apart from an older one whose id was reused after a reboot.
+	auids map[string]string
+}
+
+// process is what the log has shown of a pid.
+type process struct {
+	name string
+	ppid string
+}
+
+func newCorrelator() *correlator {
+	return &correlator{
+		procs:    make(map[string]process),
+		sessions: make(map[string]*output.Login),
+		auids:    make(map[string]string),
+	}
+}
+
+// observe sets the Ancestry and Login of e and records what e shows of its
+// process and session for the events that follow.
+func (c *correlator) observe(e *AuditEvent) {
+	pid, ppid := e.Data["pid"], e.Data["ppid"]
+	name := processName(e.Data)
+
+	if pid != "" && name != "" {
+		e.Ancestry = c.ancestry(name, ppid)
+	}
+	ses, auid := e.Data["ses"], e.Data["auid"]
+	if login, ok := c.sessions[ses]; ok && c.auids[ses] == auid {
+		e.Login = login
+	}
+
+	if pid != "" && name != "" {
+		p := c.procs[pid]
+		p.name = name
+		if ppid != "" {
+			p.ppid = ppid
+		}
+		c.procs[pid] = p
+	}
+	c.fork(e.Data)
+	if e.Type == "USER_LOGIN" && ses != "" && ses != unsetID && resName(e.Data["res"]) == "success" {
+		login := &output.Login{
+			Session:  ses,
+			User:     e.user(),
+			Addr:     e.Data["addr"],
+			Terminal: e.Data["terminal"],
+			Time:     e.Data["timestamp"],
+		}
+		c.sessions[ses] = login
+		c.auids[ses] = auid
+		e.Login = login
+	}
+}
+
+// ancestry returns the chain of processes ending with name, a process whose
+// parent is ppid.
+func (c *correlator) ancestry(name, ppid string) []string {
+	chain := []string{name}
+	seen := make(map[string]bool)
+	for ppid != "" && !seen[ppid] && len(chain) < maxAncestry {
+		seen[ppid] = true
+		p, ok := c.procs[ppid]
+		if !ok {
+			break
+		}
+		chain = append(chain, p.name)
+		ppid = p.ppid
+	}
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return chain
+}
+
+// fork records the child of a successful fork, vfork or clone as a copy of
+// its parent, so that it has a name before it calls execve.
+func (c *correlator) fork(data map[string]string) {
+	switch data["SYSCALL"] {
+	case "fork", "vfork", "clone", "clone3":
+	default:
+		return
+	}
+	child, parent := data["exit"], data["pid"]
+	if data["success"] != "yes" || child == "" || child == "0" {
+		return
+	}
+	if p, ok := c.procs[parent]; ok {
+		c.procs[child] = process{name: p.name, ppid: parent}
+	}
+}
+
+// processName returns the name of the program an event's process ran: the
+// base name of exe, or comm when exe is missing.
+func processName(data map[string]string) string {
+	if exe := data["exe"]; exe != "" && exe != "(null)" {
+		return path.Base(exe)
+	}
+	return data["comm"]
+}
@@ -0,0 +1,119 @@
+package auditd
+
+import (
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// auditd logs an untrusted string in double quotes unless it contains a
+// space, a quote or a control character; then it writes the bytes as
+// uppercase hex without quotes. EXECVE arguments and proctitle are such
+// strings, and a long EXECVE argument is split into aN[0], aN[1], …
+// chunks after an aN_len field.
+
+// decodeHex returns the bytes an unquoted auditd value encodes, or v itself
+// when it is not hex.
+func decodeHex(v string) string {
+	if len(v) == 0 || len(v)%2 != 0 {
+		return v
+	}
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		if !(c >= '0' && c <= '9' || c >= 'A' && c <= 'F') {
+			return v
+		}
+	}
+	b, err := hex.DecodeString(v)
+	if err != nil {
+		return v
+	}
+	return string(b)
+}
+
+// decodeProctitle decodes an unquoted proctitle, the process's argv
+// separated by NUL bytes, into a space-separated command line.
+func decodeProctitle(v string) string {
+	return strings.TrimRight(strings.ReplaceAll(decodeHex(v), "\x00", " "), " ")
+}
+
+// decodeUntrusted decodes the unquoted value of a field auditd logs as an
+// untrusted string.
+func decodeUntrusted(key, value string) string {
+	switch key {
+	case "proctitle":
+		return decodeProctitle(value)
+	case "name", "cwd", "exe", "comm", "acct":
+		return decodeHex(value)
+	}
+	return value
+}
+
+// mergeExecveArg stores an EXECVE argument field in dest, decoded, and
+// reports whether key was one. Arguments replace the SYSCALL record's a0–a3,
+// which only hold raw register values; chunks of a split argument are
+// appended to it in order.
+func mergeExecveArg(dest map[string]string, key, value string, quoted bool) bool {
+	if len(key) < 2 || key[0] != 'a' || key[1] < '0' || key[1] > '9' {
+		return false
+	}
+	if !quoted {
+		value = decodeHex(value)
+	}
+	name, chunk, split := strings.Cut(key, "[")
+	if !split {
+		if _, err := strconv.Atoi(key[1:]); err != nil {
+			// aN_len
+			return false
+		}
+		dest[key] = value
+		return true
+	}
+	if chunk == "0]" {
+		dest[name] = value
+	} else {
+		dest[name] += value
+	}
+	return true
+}
+
+// newEvent builds the event for a merged record group, adding CommandLine,
+// the EXECVE arguments joined by spaces, when the group has an EXECVE
+// record. Only the arguments the record holds are joined, so a malformed
+// argc cannot make it allocate more.
+func newEvent(g map[string]string) AuditEvent {
+	if argc, err := strconv.Atoi(g["argc"]); err == nil && argc >= 0 {
+		var indexes []int
+		for key := range g {
+			if i, ok := argIndex(key); ok && i < argc {
+				indexes = append(indexes, i)
+			}
+		}
+		sort.Ints(indexes)
+		args := make([]string, 0, len(indexes))
+		for _, i := range indexes {
+			args = append(args, g["a"+strconv.Itoa(i)])
+		}
+		// A SYSCALL register beyond argc is not an argument.
+		for i := argc; i < 4; i++ {
+			delete(g, "a"+strconv.Itoa(i))
+		}
+		g["CommandLine"] = strings.Join(args, " ")
+	}
+	return AuditEvent{Type: g["type"], Data: g}
+}
+
+// argIndex returns N for an aN argument field.
+func argIndex(key string) (int, bool) {
+	if len(key) < 2 || key[0] != 'a' {
+		return 0, false
+	}
+	for i := 1; i < len(key); i++ {
+		if key[i] < '0' || key[i] > '9' {
+			return 0, false
+		}
+	}
+	n, err := strconv.Atoi(key[1:])
+	return n, err == nil
+}
@@ -0,0 +1,142 @@
+package auditd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// auditd logs numbers where analysts think in names: syscall=59 for execve,
+// auid=1000 for alice. With log_format=ENRICHED it appends the interpreted
+// values after a \x1d separator as uppercase fields (ARCH, SYSCALL, AUID,
+// UID, …). For logs written without it, interpret fills in the same fields
+// the way ausearch -i would. Fields already present in the log always win.
+
+// archNames holds the names of the architectures syscallNames covers, by
+// their arch= value.
+var archNames = map[string]string{
+	"c000003e": "x86_64",
+	"40000003": "i386",
+	"c00000b7": "aarch64",
+}
+
+// uidFields are the fields holding a uid; each is interpreted into the field
+// of the same name in upper case.
+var uidFields = []string{"auid", "uid", "euid", "suid", "fsuid", "ouid"}
+
+// interpret adds the interpreted fields of a record's raw fields to data,
+// naming uids with users.
+func interpret(data map[string]string, users map[string]string) {
+	set := func(key, value string) {
+		if _, ok := data[key]; !ok {
+			data[key] = value
+		}
+	}
+	arch := data["arch"]
+	if name, ok := archNames[arch]; ok {
+		set("ARCH", name)
+	}
+	if nr, ok := data["syscall"]; ok {
+		set("SYSCALL", syscallName(arch, nr))
+	}
+	for _, key := range uidFields {
+		if uid, ok := data[key]; ok {
+			set(strings.ToUpper(key), userName(uid, users))
+		}
+	}
+	if exit, ok := data["exit"]; ok {
+		set("EXIT", exitName(exit))
+	}
+	if res, ok := data["res"]; ok {
+		set("RES", resName(res))
+	}
+}
+
+// syscallName returns the name of syscall nr on arch, or nr when either is
+// unknown.
+func syscallName(arch, nr string) string {
+	n, err := strconv.Atoi(nr)
+	names := syscallNames[arch]
+	if err != nil || n < 0 || n >= len(names) || names[n] == "" {
+		return nr
+	}
+	return names[n]
+}
+
+// userName returns the account name of uid: the one in users, root, or
+// unset for the auid of a process no user logged in to. Other uids stay
+// numeric.
+func userName(uid string, users map[string]string) string {
+	if name, ok := users[uid]; ok {
+		return name
+	}
+	switch uid {
+	case "0":
+		return "root"
+	case "4294967295", "-1":
+		return "unset"
+	}
+	return uid
+}
+
+// exitName returns the errno name of a failed syscall's negative exit
+// value, such as EACCES for -13. Other values are returned unchanged.
+func exitName(exit string) string {
+	n, err := strconv.Atoi(exit)
+	// The bound is checked before negating, since -n overflows for the
+	// smallest int.
+	if err != nil || n >= 0 || n <= -len(errnoNames) || errnoNames[-n] == "" {
+		return exit
+	}
+	return errnoNames[-n]
+}
+
+// resName spells out the res=1 and res=0 of older records, and the
+// res=yes and res=no some userspace tools log.
+func resName(res string) string {
+	switch res {
+	case "1", "yes":
+		return "success"
+	case "0", "no":
+		return "failed"
+	}
+	return res
+}
+
+// LoadPasswd reads a passwd(5) file, typically the /etc/passwd of the host
+// that wrote the logs, and returns its account names keyed by uid. The
+// first entry for a uid wins, as it does for getpwuid.
+func LoadPasswd(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	users := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Split(text, ":")
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("%s:%d: not a passwd entry", path, line)
+		}
+		if _, err := strconv.ParseUint(fields[2], 10, 32); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid uid %q", path, line, fields[2])
+		}
+		if _, ok := users[fields[2]]; !ok {
+			users[fields[2]] = fields[0]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
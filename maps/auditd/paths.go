@@ -0,0 +1,85 @@
+package auditd
+
+import (
+	"strconv"
+	"strings"
+
+	sigma "github.com/M00NLIG7/go-sigma-rule-engine"
+)
+
+// recordGroup collects the records auditd wrote for one event.
+type recordGroup struct {
+	// data holds the fields of all records, first record wins.
+	data  map[string]string
+	paths []map[string]string
+}
+
+// event builds the group's event, interpreting its fields with users.
+func (g *recordGroup) event(users map[string]string) AuditEvent {
+	e := newEvent(g.data)
+	interpret(e.Data, users)
+	for _, p := range g.paths {
+		interpret(p, users)
+	}
+	e.Paths = g.paths
+	return e
+}
+
+// parsePath returns the fields of a PATH record.
+func parsePath(line string) map[string]string {
+	fields := make(map[string]string, 16)
+	scanPairs(line, func(key, value string, quoted bool) {
+		if key == "msg" {
+			return
+		}
+		if !quoted {
+			value = decodeUntrusted(key, value)
+		}
+		fields[key] = value
+	})
+	return fields
+}
+
+// pathField looks up a PATH[i].field name.
+func (e AuditEvent) pathField(name string) (string, bool) {
+	index, field, ok := strings.Cut(name[len("PATH["):], "].")
+	if !ok {
+		return "", false
+	}
+	i, err := strconv.Atoi(index)
+	if err != nil || i < 0 || i >= len(e.Paths) {
+		return "", false
+	}
+	value, ok := e.Paths[i][field]
+	return value, ok
+}
+
+// Records satisfies the rules.Records interface. An event that touched
+// several paths, such as a file created in a directory or a rename, is also
+// matched once per PATH record, with that record's type, name, nametype and
+// mode in place of the first record's, so a rule sees every path and never
+// mixes fields of different records.
+func (e AuditEvent) Records() []sigma.Event {
+	if len(e.Paths) == 0 {
+		return nil
+	}
+	records := make([]sigma.Event, len(e.Paths))
+	for i, p := range e.Paths {
+		records[i] = pathRecord{AuditEvent: e, fields: p}
+	}
+	return records
+}
+
+// pathRecord is an event seen through one of its PATH records.
+type pathRecord struct {
+	AuditEvent
+	fields map[string]string
+}
+
+// Select satisfies the sigma.Event interface.
+func (r pathRecord) Select(name string) (interface{}, bool) {
+	if value, ok := r.fields[name]; ok {
+		return value, true
+	}
+	return r.AuditEvent.Select(name)
+}
@@ -0,0 +1,107 @@
+// Package chop holds the scan pipeline shared by every log target: the Source
+// interface and registry, the command-line options, the rule-evaluation worker
+// pool and Run, which ties them together.
+package chop
+
+import (
+	"time"
+
+	"github.com/M00NLIG7/ChopChopGo/maps/output"
+	"github.com/M00NLIG7/ChopChopGo/maps/rules"
+	sigma "github.com/M00NLIG7/go-sigma-rule-engine"
+)
+
+// Options carries the command-line settings of a scan. Run and Triage use
+// them and pass them on to each Source's Stream, which ignores the fields
+// that do not apply to it.
+type Options struct {
+	// RulePaths lists the Sigma rules to load, as given to -rules: rule
+	// directories, individual rule files and glob patterns.
+	RulePaths []string
+	// Logsources selects the rules that run on each target by their
+	// logsource; nil runs every rule on every target.
+	Logsources rules.Table
+	// Placeholders holds the values of the %name% placeholders that the
+	// |expand modifier substitutes.
+	Placeholders map[string][]string
+	// OutputType is "json", "csv", or anything else for a table.
+	OutputType string
+	// Files lists the logs to scan, as given to -file; entries may be glob
+	// patterns. When empty the target's default log location is used.
+	Files []string
+	// MappingPath overrides the target's built-in field mapping when non-empty.
+	MappingPath string
+	// Workers is the number of goroutines evaluating rules. Values below 2
+	// evaluate on the calling goroutine.
+	Workers int
+	// FirstMatch keeps only the first matching rule per event instead of
+	// reporting every rule that fired.
+	FirstMatch bool
+	// Location is the time zone of timestamps that carry no offset. Nil
+	// means the local zone.
+	Location *time.Location
+	// Range limits the scan to events inside the -since/-until window.
+	Range TimeRange
+	// Context adds the process ancestry and login columns to the results,
+	// for sources that fill them in.
+	Context bool
+	// Settings holds the values of each source's own flags, keyed by source
+	// name, as a Flagger records them.
+	Settings map[string]interface{}
+	// Follow keeps reading the logs as they grow, like tail -F, and writes
+	// each match as soon as it is found. Without a -since starting point only
+	// new events are scanned.
+	Follow bool
+	// Stop ends a Follow scan when closed; nil follows forever.
+	Stop <-chan struct{}
+	// State, when set, resumes each log from its -state checkpoint and
+	// records how far it was read.
+	State *State
+}
+
+// ruleConfig returns the settings rules.Load needs.
+func (o Options) ruleConfig() rules.Config {
+	return rules.Config{Directory: o.RulePaths, Logsources: o.Logsources, Placeholders: o.Placeholders}
+}
+
+// ShowProgress reports whether the progress bar and summary line should be
+// printed, which is only the case for the human-readable table output.
+func (o Options) ShowProgress() bool {
+	return o.OutputType != "json" && o.OutputType != "csv"
+}
+
+// FollowFromStart reports whether a Follow scan should read the existing
+// content of a log before waiting for new events.
+func (o Options) FollowFromStart() bool {
+	return !o.Range.Since.IsZero()
+}
+
+// Setting returns the settings recorded for the source name, or nil.
+func (o Options) Setting(name string) interface{} {
+	return o.Settings[name]
+}
+
+// SetSetting records the settings of the source name.
+func (o *Options) SetSetting(name string, v interface{}) {
+	if o.Settings == nil {
+		o.Settings = make(map[string]interface{})
+	}
+	o.Settings[name] = v
+}
+
+// Matches converts sigma results into output matches, honouring FirstMatch.
+func (o Options) Matches(res sigma.Results) []output.Match {
+	if o.FirstMatch && len(res) > 1 {
+		res = res[:1]
+	}
+	matches := make([]output.Match, 0, len(res))
+	for _, r := range res {
+		matches = append(matches, output.Match{
+			RuleID: r.ID,
+			Title:  r.Title,
+			Tags:   r.Tags,
+			Author: r.Author,
+		})
+	}
+	return matches
+}
@@ -0,0 +1,29 @@
+package chop
+
+import (
+	"testing"
+
+	sigma "github.com/M00NLIG7/go-sigma-rule-engine"
+)
+
+var twoResults = sigma.Results{
+	{ID: "a", Title: "First", Tags: sigma.Tags{"t1"}, Author: "x"},
+	{ID: "b", Title: "Second", Tags: sigma.Tags{"t2"}, Author: "y"},
+}
+
+func TestMatchesAll(t *testing.T) {
+	m := Options{}.Matches(twoResults)
+	if len(m) != 2 {
+		t.Fatalf("expected 2 matches, got %d", len(m))
+	}
+	if m[1].RuleID != "b" || m[1].Title != "Second" || m[1].Tags[0] != "t2" || m[1].Author != "y" {
+		t.Errorf("second match not copied correctly: %+v", m[1])
+	}
+}
+
+func TestMatchesFirstOnly(t *testing.T) {
+	m := Options{FirstMatch: true}.Matches(twoResults)
+	if len(m) != 1 || m[0].RuleID != "a" {
+		t.Errorf("FirstMatch should keep only the first result, got %+v", m)
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package chop
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileID returns the device and inode identifying the file behind info,
+// which survive renames such as log rotation.
+func fileID(info os.FileInfo) (dev, ino uint64) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0
+	}
+	return uint64(st.Dev), uint64(st.Ino)
+}
@@ -0,0 +1,56 @@
+package chop
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// StringList is a flag.Value for options that may be repeated and whose
+// values may also be comma-separated, e.g. -file a.log,b.log -file c.log.
+type StringList []string
+
+// String satisfies the flag.Value interface.
+func (l *StringList) String() string { return strings.Join(*l, ",") }
+
+// Set satisfies the flag.Value interface.
+func (l *StringList) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+// ExpandFiles resolves -file patterns into concrete paths. Patterns containing
+// glob metacharacters are expanded with filepath.Glob and must match at least
+// one file; other entries are passed through for the source's FindLog to
+// validate. Duplicates are dropped so overlapping globs scan a file once.
+func ExpandFiles(patterns []string) ([]string, error) {
+	var files []string
+	seen := make(map[string]bool)
+	add := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			files = append(files, path)
+		}
+	}
+	for _, p := range patterns {
+		if !strings.ContainsAny(p, "*?[") {
+			add(p)
+			continue
+		}
+		matches, err := filepath.Glob(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid file pattern %q: %w", p, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("file pattern %q matched no files", p)
+		}
+		for _, m := range matches {
+			add(m)
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,63 @@
+package chop
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStringListSet(t *testing.T) {
+	var l StringList
+	for _, v := range []string{"a.log", "b.log, c.log", ",d.log,"} {
+		if err := l.Set(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := strings.Join(l, "|"); got != "a.log|b.log|c.log|d.log" {
+		t.Errorf("unexpected list: %q", got)
+	}
+}
+
+func TestExpandFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"auth.log", "auth.log.1", "auth.log.2.gz", "syslog"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := ExpandFiles([]string{
+		filepath.Join(dir, "auth.log*"),
+		filepath.Join(dir, "auth.log"), // duplicate of a glob match
+		filepath.Join(dir, "syslog"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"auth.log", "auth.log.1", "auth.log.2.gz", "syslog"}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d files, got %v", len(want), got)
+	}
+	for i, w := range want {
+		if filepath.Base(got[i]) != w {
+			t.Errorf("file %d: got %q, want %q", i, filepath.Base(got[i]), w)
+		}
+	}
+}
+
+func TestExpandFilesNoMatch(t *testing.T) {
+	if _, err := ExpandFiles([]string{filepath.Join(t.TempDir(), "*.log")}); err == nil {
+		t.Error("expected error for a pattern matching nothing")
+	}
+}
+
+func TestExpandFilesLiteralPassthrough(t *testing.T) {
+	got, err := ExpandFiles([]string{"/does/not/exist.log"})
+	if err != nil {
+		t.Fatalf("literal paths should be left for FindLog to validate, got %v", err)
+	}
+	if len(got) != 1 || got[0] != "/does/not/exist.log" {
+		t.Errorf("unexpected result %v", got)
+	}
+}
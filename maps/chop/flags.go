@@ -0,0 +1,68 @@
+package chop
+
+import (
+	"flag"
+	"fmt"
+)
+
+// Flagger is implemented by sources with command-line flags of their own,
+// such as the journal filters of journald. Their flags are registered next
+// to the shared ones, so a source adds flags without touching main.
+type Flagger interface {
+	Source
+	// Flags registers the source's flags on fs. Once fs is parsed, the
+	// returned apply checks their values and records them in opts.Settings
+	// under the source's name, for Stream to read back. triage is set for
+	// -dir and -archive scans, which hand files to every source.
+	Flags(fs *flag.FlagSet) (apply func(opts *Options, triage bool) error)
+}
+
+// SourceFlags are the flags of every registered Flagger.
+type SourceFlags struct {
+	owners   map[string]string
+	appliers map[string]func(*Options, bool) error
+}
+
+// RegisterSourceFlags registers the flags of every Flagger source on fs.
+func RegisterSourceFlags(fs *flag.FlagSet) *SourceFlags {
+	sf := &SourceFlags{owners: make(map[string]string), appliers: make(map[string]func(*Options, bool) error)}
+	for _, name := range Names() {
+		src, _ := Lookup(name)
+		f, ok := src.(Flagger)
+		if !ok {
+			continue
+		}
+		own := flag.NewFlagSet(name, flag.ContinueOnError)
+		sf.appliers[name] = f.Flags(own)
+		own.VisitAll(func(fl *flag.Flag) {
+			fs.Var(fl.Value, fl.Name, fl.Usage)
+			sf.owners[fl.Name] = name
+		})
+	}
+	return sf
+}
+
+// Apply records the source flags given on the parsed fs in opts. A scan of
+// target only accepts that source's flags, while a triage scan passes them
+// to every source.
+func (sf *SourceFlags) Apply(fs *flag.FlagSet, target string, triage bool, opts *Options) error {
+	var err error
+	fs.Visit(func(fl *flag.Flag) {
+		if owner, ok := sf.owners[fl.Name]; ok && !triage && owner != target && err == nil {
+			err = fmt.Errorf("-%s requires -target %s", fl.Name, owner)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	for _, name := range Names() {
+		apply, ok := sf.appliers[name]
+		if !ok || !triage && name != target {
+			continue
+		}
+		if err := apply(opts, triage); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package chop
+
+import (
+	"flag"
+	"testing"
+)
+
+// flagSource is a source with a -level flag of its own.
+type flagSource struct{ fakeSource }
+
+func (flagSource) Name() string { return "flagged" }
+func (flagSource) Flags(fs *flag.FlagSet) func(*Options, bool) error {
+	level := fs.Int("level", 0, "flagged only: level")
+	return func(opts *Options, triage bool) error {
+		opts.SetSetting("flagged", *level)
+		return nil
+	}
+}
+
+func TestSourceFlags(t *testing.T) {
+	Register(flagSource{})
+	defer func() {
+		registryMu.Lock()
+		delete(registry, "flagged")
+		registryMu.Unlock()
+	}()
+
+	parse := func(args ...string) (*flag.FlagSet, *SourceFlags) {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		sf := RegisterSourceFlags(fs)
+		if err := fs.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		return fs, sf
+	}
+
+	fs, sf := parse("-level", "3")
+	var opts Options
+	if err := sf.Apply(fs, "flagged", false, &opts); err != nil || opts.Setting("flagged") != 3 {
+		t.Errorf("expected the source's flag to be recorded, got %v, %v", opts.Settings, err)
+	}
+	opts = Options{}
+	if err := sf.Apply(fs, "fake", false, &opts); err == nil {
+		t.Error("a source's flag should be rejected with another -target")
+	}
+	opts = Options{}
+	if err := sf.Apply(fs, "", true, &opts); err != nil || opts.Setting("flagged") != 3 {
+		t.Errorf("triage should pass the flag to its source, got %v, %v", opts.Settings, err)
+	}
+
+	fs, sf = parse()
+	opts = Options{}
+	if err := sf.Apply(fs, "fake", false, &opts); err != nil || opts.Setting("flagged") != nil {
+		t.Errorf("another target's settings should not be recorded, got %v, %v", opts.Settings, err)
+	}
+}
@@ -0,0 +1,22 @@
+package chop
+
+import (
+	"io"
+
+	"github.com/M00NLIG7/ChopChopGo/maps/input"
+)
+
+// OpenLog opens path for a file-based source. A normal scan reads the whole
+// log through input.Open, decompressing it if needed; with opts.Follow the
+// log is tailed with input.Follow until opts.Stop is closed, and the
+// returned reader is an *input.Follower. With opts.State the log is read
+// from its checkpoint and its new checkpoint is saved with the state.
+func OpenLog(path string, opts Options) (io.ReadCloser, error) {
+	if opts.State != nil {
+		return opts.State.openLog(path, opts)
+	}
+	if opts.Follow {
+		return input.Follow(path, opts.FollowFromStart(), opts.Stop)
+	}
+	return input.Open(path)
+}
@@ -0,0 +1,129 @@
+package chop
+
+import (
+	"sync"
+
+	sigma "github.com/M00NLIG7/go-sigma-rule-engine"
+)
+
+// EvalFunc evaluates a single event against a set of rules. sigma.Ruleset's
+// EvalAll method satisfies it and is safe for concurrent use.
+type EvalFunc func(sigma.Event) (sigma.Results, bool)
+
+// MatchFunc receives an event that matched at least one rule, together with
+// every rule result it produced.
+type MatchFunc func(sigma.Event, sigma.Results)
+
+// inflightPerWorker bounds how many submitted-but-not-yet-delivered events
+// each worker may have outstanding. It keeps the reorder buffer, and therefore
+// memory, proportional to the worker count rather than the log size.
+const inflightPerWorker = 64
+
+type job struct {
+	idx   uint64
+	event sigma.Event
+}
+
+type outcome struct {
+	idx   uint64
+	event sigma.Event
+	res   sigma.Results
+	match bool
+}
+
+// Pool fans events out to a fixed number of goroutines that evaluate them
+// concurrently, then hands matches to a MatchFunc in the exact order the
+// events were submitted. The MatchFunc is only ever called from one goroutine
+// at a time, so it can append to a slice or write output without locking.
+//
+// With fewer than two workers the pool evaluates inline on the submitting
+// goroutine, which keeps the single-threaded path free of channel overhead.
+type Pool struct {
+	eval    EvalFunc
+	onMatch MatchFunc
+
+	next  uint64
+	jobs  chan job
+	out   chan outcome
+	slots chan struct{}
+
+	workers   sync.WaitGroup
+	collector sync.WaitGroup
+}
+
+// NewPool starts workers goroutines evaluating with eval and delivering
+// matches to onMatch. Callers must call Close once all events are submitted.
+func NewPool(workers int, eval EvalFunc, onMatch MatchFunc) *Pool {
+	p := &Pool{eval: eval, onMatch: onMatch}
+	if workers < 2 {
+		return p
+	}
+
+	p.jobs = make(chan job, workers)
+	p.out = make(chan outcome, workers)
+	p.slots = make(chan struct{}, workers*inflightPerWorker)
+
+	p.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer p.workers.Done()
+			for j := range p.jobs {
+				res, match := p.eval(j.event)
+				p.out <- outcome{idx: j.idx, event: j.event, res: res, match: match}
+			}
+		}()
+	}
+
+	p.collector.Add(1)
+	go p.collect()
+	return p
+}
+
+// collect reassembles worker outcomes into submission order. Outcomes that
+// arrive early are parked in pending until every earlier index is delivered.
+func (p *Pool) collect() {
+	defer p.collector.Done()
+	pending := make(map[uint64]outcome)
+	var want uint64
+	for o := range p.out {
+		pending[o.idx] = o
+		for {
+			next, ok := pending[want]
+			if !ok {
+				break
+			}
+			delete(pending, want)
+			if next.match {
+				p.onMatch(next.event, next.res)
+			}
+			<-p.slots
+			want++
+		}
+	}
+}
+
+// Submit queues event for evaluation. It blocks when too many events are
+// outstanding, applying backpressure to the parser feeding the pool.
+func (p *Pool) Submit(event sigma.Event) {
+	if p.jobs == nil {
+		if res, match := p.eval(event); match {
+			p.onMatch(event, res)
+		}
+		return
+	}
+	p.slots <- struct{}{}
+	p.jobs <- job{idx: p.next, event: event}
+	p.next++
+}
+
+// Close waits for every submitted event to be evaluated and every match to be
+// delivered. The pool must not be used afterwards.
+func (p *Pool) Close() {
+	if p.jobs == nil {
+		return
+	}
+	close(p.jobs)
+	p.workers.Wait()
+	close(p.out)
+	p.collector.Wait()
+}
@@ -0,0 +1,65 @@
+package chop
+
+import (
+	"runtime"
+	"strconv"
+	"testing"
+
+	sigma "github.com/M00NLIG7/go-sigma-rule-engine"
+)
+
+// numEvent is a minimal sigma.Event carrying its submission index.
+type numEvent int
+
+func (e numEvent) Keywords() ([]string, bool)        { return []string{strconv.Itoa(int(e))}, true }
+func (e numEvent) Select(string) (interface{}, bool) { return nil, false }
+
+// evalEvenSlowly matches even-numbered events and yields the scheduler on
+// every call so that workers finish out of order.
+func evalEvenSlowly(e sigma.Event) (sigma.Results, bool) {
+	n := int(e.(numEvent))
+	for i := 0; i < n%7; i++ {
+		runtime.Gosched()
+	}
+	if n%2 != 0 {
+		return nil, false
+	}
+	return sigma.Results{{ID: strconv.Itoa(n)}}, true
+}
+
+func runPool(t *testing.T, workers, total int) []int {
+	t.Helper()
+	var got []int
+	pool := NewPool(workers, evalEvenSlowly, func(e sigma.Event, res sigma.Results) {
+		if res[0].ID != strconv.Itoa(int(e.(numEvent))) {
+			t.Errorf("result %q delivered with event %d", res[0].ID, e)
+		}
+		got = append(got, int(e.(numEvent)))
+	})
+	for i := 0; i < total; i++ {
+		pool.Submit(numEvent(i))
+	}
+	pool.Close()
+	return got
+}
+
+func TestPoolPreservesOrder(t *testing.T) {
+	const total = 5000
+	for _, workers := range []int{0, 1, 2, 8, 32} {
+		got := runPool(t, workers, total)
+		if len(got) != total/2 {
+			t.Fatalf("workers=%d: expected %d matches, got %d", workers, total/2, len(got))
+		}
+		for i, n := range got {
+			if n != i*2 {
+				t.Fatalf("workers=%d: match %d out of order: got event %d, want %d", workers, i, n, i*2)
+			}
+		}
+	}
+}
+
+func TestPoolCloseWithoutEvents(t *testing.T) {
+	if got := runPool(t, 4, 0); len(got) != 0 {
+		t.Errorf("expected no matches, got %v", got)
+	}
+}
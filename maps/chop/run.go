@@ -0,0 +1,268 @@
+package chop
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/M00NLIG7/ChopChopGo/maps/mapping"
+	"github.com/M00NLIG7/ChopChopGo/maps/output"
+	"github.com/M00NLIG7/ChopChopGo/maps/rules"
+	sigma "github.com/M00NLIG7/go-sigma-rule-engine"
+	"github.com/schollz/progressbar/v3"
+)
+
+// Run scans src against the Sigma rules in opts.RulePaths and writes results to
+// stdout. Events are evaluated as they are parsed, so memory stays bounded by
+// the source's own buffering rather than the size of the log.
+// opts.MappingPath overrides src.DefaultMapping() when non-empty, and
+// opts.Workers spreads rule evaluation across that many goroutines while
+// keeping results in log order. The logs of a Merger source are read together
+// in time order. With opts.Follow every log is tailed at once and matches are
+// written as they occur until opts.Stop is closed.
+func Run(src Source, opts Options) error {
+	return run(os.Stdout, src, opts)
+}
+
+func run(w io.Writer, src Source, opts Options) error {
+	logPaths, err := findLogs(src, opts.Files)
+	if err != nil {
+		return err
+	}
+
+	renderer := src.Renderer()
+	if opts.Context {
+		renderer = renderer.WithContext()
+	}
+	if len(logPaths) > 1 {
+		renderer = renderer.WithFile()
+	}
+	s, err := newScan(opts, false)
+	if err != nil {
+		return err
+	}
+	if opts.Follow {
+		s.stream = output.NewStream(w, opts.OutputType, renderer)
+	}
+	m := loadMapping(src, opts.MappingPath)
+	if opts.Follow {
+		err = s.follow(src, logPaths, m, opts)
+	} else {
+		for _, group := range mergeLogs(src, logPaths) {
+			if len(group) > 1 {
+				err = s.streamMerged(src.(Merger), group, m, opts)
+			} else {
+				err = s.streamLog(src, group[0], m, opts)
+			}
+			if err != nil {
+				break
+			}
+		}
+	}
+	s.close()
+	if err != nil {
+		return err
+	}
+	if s.err != nil {
+		return fmt.Errorf("writing output: %w", s.err)
+	}
+
+	if !opts.Follow {
+		if err := output.Write(w, opts.OutputType, s.results, renderer); err != nil {
+			return fmt.Errorf("writing output: %w", err)
+		}
+	}
+	if opts.ShowProgress() {
+		fmt.Fprintf(w, "Processed %d %s events from %d file(s)\n", s.processed, src.Name(), len(logPaths))
+	}
+	return nil
+}
+
+// streamLog parses one log into the scan.
+func (s *scan) streamLog(src Source, logPath string, m *mapping.Mapping, opts Options) error {
+	err := src.Stream(logPath, opts, func(event Event) error {
+		s.submit(event, m, logPath, src.Name())
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("parsing %s log %s: %w", src.Name(), logPath, err)
+	}
+	return nil
+}
+
+// streamMerged parses the logs a Merger reads together into the scan. Their
+// results record all of the logs, since an event does not tell which one it
+// came from.
+func (s *scan) streamMerged(src Merger, logPaths []string, m *mapping.Mapping, opts Options) error {
+	file := strings.Join(logPaths, ", ")
+	err := src.StreamMerged(logPaths, opts, func(event Event) error {
+		s.submit(event, m, file, src.Name())
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("parsing %s logs %s: %w", src.Name(), file, err)
+	}
+	return nil
+}
+
+// mergeLogs groups the logs of one scan in the order they are read: every
+// log a Merger source can merge goes into one group, in the place of the
+// first of them, and any other log is a group of its own.
+func mergeLogs(src Source, logPaths []string) [][]string {
+	var groups [][]string
+	merged := -1
+	for _, logPath := range logPaths {
+		if mg, ok := src.(Merger); ok && mg.Mergeable(logPath) {
+			if merged < 0 {
+				merged = len(groups)
+				groups = append(groups, nil)
+			}
+			groups[merged] = append(groups[merged], logPath)
+			continue
+		}
+		groups = append(groups, []string{logPath})
+	}
+	return groups
+}
+
+// follow tails every log concurrently, since each stream only ends when
+// opts.Stop is closed. It returns the first error once all have ended.
+func (s *scan) follow(src Source, logPaths []string, m *mapping.Mapping, opts Options) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(logPaths))
+	for i, logPath := range logPaths {
+		wg.Add(1)
+		go func(i int, logPath string) {
+			defer wg.Done()
+			errs[i] = s.streamLog(src, logPath, m, opts)
+		}(i, logPath)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scan is the evaluation state of one run: the worker pool delivering
+// results in order and the progress bar. Sources push events into it with
+// submit; close drains the pool so results is complete. When stream is set,
+// results are written to it as they are delivered instead of being
+// collected, and the first write error is kept in err.
+type scan struct {
+	pool      *Pool
+	bar       *progressbar.ProgressBar
+	results   []output.ScanResult
+	stream    *output.Stream
+	err       error
+	processed int
+
+	// mu serialises submit for followed logs, which are parsed on one
+	// goroutine each.
+	mu sync.Mutex
+}
+
+// newScan loads the rules for a scan. mixed marks a scan of several
+// sources, whose results record the source of each event.
+func newScan(opts Options, mixed bool) (*scan, error) {
+	set, err := rules.Load(opts.ruleConfig())
+	if err != nil {
+		return nil, fmt.Errorf("loading ruleset: %w", err)
+	}
+
+	s := &scan{}
+	if opts.ShowProgress() && !opts.Follow {
+		// The event count is unknown until the stream ends, so show a spinner.
+		s.bar = progressbar.Default(-1)
+	}
+	eval := func(e sigma.Event) (sigma.Results, bool) {
+		return set.Eval(e.(taggedEvent).target, e)
+	}
+	s.pool = NewPool(opts.Workers, eval, func(e sigma.Event, res sigma.Results) {
+		te := e.(taggedEvent)
+		result := te.Event.Result()
+		// Aggregations and correlations see matches in log order here.
+		at, _ := time.Parse(time.RFC3339, result.Timestamp)
+		if res = set.Correlate(e, at, res); len(res) == 0 {
+			return
+		}
+		result.File = te.file
+		if mixed {
+			result.Target = te.target
+		}
+		result.SetMatches(opts.Matches(res))
+		if s.stream == nil {
+			s.results = append(s.results, result)
+		} else if err := s.stream.Write(result); err != nil && s.err == nil {
+			s.err = err
+		}
+	})
+	return s, nil
+}
+
+// submit queues event for evaluation, tagged with the log it came from and
+// the name of the source that parsed it, which selects the rules that apply.
+func (s *scan) submit(event Event, m *mapping.Mapping, file, target string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pool.Submit(taggedEvent{NewMapped(event, m), file, target})
+	s.processed++
+	if s.bar != nil {
+		s.bar.Add(1)
+	}
+}
+
+func (s *scan) close() {
+	s.pool.Close()
+	if s.bar != nil {
+		s.bar.Finish()
+	}
+}
+
+// taggedEvent carries a mapped event's origin through the pool, so the rules
+// for its source are applied and results can record where they came from
+// when several logs are scanned in one run.
+type taggedEvent struct {
+	Mapped
+	file   string
+	target string
+}
+
+// loadMapping loads the mapping at path, falling back to the source's default
+// mapping file and then to an identity mapping.
+func loadMapping(src Source, path string) *mapping.Mapping {
+	if path == "" {
+		path = src.DefaultMapping()
+	}
+	return mapping.LoadOrIdentity(path, src.Name())
+}
+
+// findLogs expands the -file patterns and resolves each one through the
+// source. With no patterns the source's default location is used.
+func findLogs(src Source, patterns []string) ([]string, error) {
+	if len(patterns) == 0 {
+		path, err := src.FindLog("")
+		if err != nil {
+			return nil, fmt.Errorf("finding %s log: %w", src.Name(), err)
+		}
+		return []string{path}, nil
+	}
+	files, err := ExpandFiles(patterns)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(files))
+	for _, f := range files {
+		path, err := src.FindLog(f)
+		if err != nil {
+			return nil, fmt.Errorf("finding %s log: %w", src.Name(), err)
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
@@ -0,0 +1,163 @@
+package chop
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/M00NLIG7/ChopChopGo/maps/mapping"
+	"github.com/M00NLIG7/ChopChopGo/maps/output"
+	"github.com/M00NLIG7/ChopChopGo/maps/rules"
+	sigma "github.com/M00NLIG7/go-sigma-rule-engine"
+)
+
+// Event is a parsed log record that can be evaluated against Sigma rules and
+// reported as a ScanResult.
+type Event interface {
+	sigma.Event
+	// Result returns the output columns describing this event. The rule
+	// fields are filled in by Run once the event has matched.
+	Result() output.ScanResult
+}
+
+// Source is a log format ChopChopGo knows how to locate, parse and render.
+// Implementations register themselves from an init function so that new
+// formats plug in without touching main.
+type Source interface {
+	// Name is the -target value that selects this source.
+	Name() string
+	// FindLog resolves the log to scan. file is the -file value, empty when
+	// the user wants the source's default location.
+	FindLog(file string) (string, error)
+	// Stream parses the log returned by FindLog and calls emit once per event
+	// in log order. A non-nil error from emit stops the stream and is
+	// returned unchanged. opts carries the parse-time settings (such as
+	// -year and -tz); sources ignore those that do not apply to them.
+	Stream(path string, opts Options, emit func(Event) error) error
+	// Fields lists the native field names the source's events can select.
+	Fields() []string
+	// Renderer describes the table and CSV columns for this source.
+	Renderer() output.Renderer
+	// DefaultMapping is the mapping file loaded when -mapping is not given.
+	DefaultMapping() string
+}
+
+// Detector is implemented by file-based sources that can recognise their own
+// format from the start of a log and parse an already-open stream. Directory
+// and archive scans use it to route each file to the right source.
+type Detector interface {
+	Source
+	// Detect reports whether head, the first few KiB of a decompressed log,
+	// is in this source's format.
+	Detect(head []byte) bool
+	// StreamReader parses r exactly as Stream parses a file. modTime is the
+	// log's modification time, which sources whose timestamps omit the year
+	// use as a reference; it is zero when unknown.
+	StreamReader(r io.Reader, modTime time.Time, opts Options, emit func(Event) error) error
+}
+
+// OpenFields is implemented by sources whose events carry fields beyond the
+// fixed list of Fields, such as the arbitrary key=value pairs of auditd
+// records or journal fields set by applications. Validate accepts any field
+// HasField reports rather than warning about it.
+type OpenFields interface {
+	Source
+	// HasField reports whether name is a valid field name for the source's
+	// events, whether or not Fields lists it.
+	HasField(name string) bool
+}
+
+// Merger is implemented by sources whose logs interleave in time, such as
+// the binary journal files of one host. Run reads every log Mergeable
+// accepts in a single StreamMerged call, so that their events are evaluated
+// in time order rather than one log after the other.
+type Merger interface {
+	Source
+	// Mergeable reports whether StreamMerged can read the log at path.
+	Mergeable(path string) bool
+	// StreamMerged parses the logs at paths as Stream parses one, calling
+	// emit with the events of all of them in time order.
+	StreamMerged(paths []string, opts Options, emit func(Event) error) error
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Source)
+)
+
+// Register makes src available under src.Name(). It panics when a source is
+// registered twice, which can only happen through a programming error.
+func Register(src Source) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	name := src.Name()
+	if _, dup := registry[name]; dup {
+		panic(fmt.Sprintf("chop: source %q registered twice", name))
+	}
+	registry[name] = src
+}
+
+// Lookup returns the source registered under name.
+func Lookup(name string) (Source, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	src, ok := registry[name]
+	return src, ok
+}
+
+// Names returns the registered source names in sorted order.
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Mapped wraps an Event with a field-name mapping so that Sigma rules written
+// with generic field names (e.g. CommandLine → exe) are resolved to the
+// source's native names before Select is called.
+type Mapped struct {
+	Event
+	m *mapping.Mapping
+}
+
+// NewMapped wraps e so that Select resolves names through m.
+func NewMapped(e Event, m *mapping.Mapping) Mapped {
+	return Mapped{Event: e, m: m}
+}
+
+// Select satisfies the sigma.Event interface.
+func (e Mapped) Select(name string) (interface{}, bool) {
+	return e.Event.Select(e.m.Resolve(name))
+}
+
+// Records satisfies the rules.Records interface, mapping the records of an
+// event that has them.
+func (e Mapped) Records() []sigma.Event {
+	r, ok := e.Event.(rules.Records)
+	if !ok {
+		return nil
+	}
+	records := r.Records()
+	for i, rec := range records {
+		records[i] = mappedRecord{Event: rec, m: e.m}
+	}
+	return records
+}
+
+// mappedRecord is Mapped for one record of an event.
+type mappedRecord struct {
+	sigma.Event
+	m *mapping.Mapping
+}
+
+// Select satisfies the sigma.Event interface.
+func (r mappedRecord) Select(name string) (interface{}, bool) {
+	return r.Event.Select(r.m.Resolve(name))
+}
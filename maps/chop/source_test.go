@@ -0,0 +1,205 @@
+package chop
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/M00NLIG7/ChopChopGo/maps/mapping"
+	"github.com/M00NLIG7/ChopChopGo/maps/output"
+)
+
+// fakeEvent is a single-field event used to exercise the pipeline.
+type fakeEvent struct{ msg string }
+
+func (e fakeEvent) Keywords() ([]string, bool) { return []string{e.msg}, true }
+func (e fakeEvent) Select(name string) (interface{}, bool) {
+	if name == "msg" {
+		return e.msg, true
+	}
+	return nil, false
+}
+func (e fakeEvent) Result() output.ScanResult { return output.ScanResult{Message: e.msg} }
+
+// fakeSource streams a fixed list of messages.
+type fakeSource struct{ msgs []string }
+
+func (fakeSource) Name() string { return "fake" }
+func (fakeSource) FindLog(file string) (string, error) {
+	if file == "" {
+		return "fake.log", nil
+	}
+	return file, nil
+}
+func (s fakeSource) Stream(path string, opts Options, emit func(Event) error) error {
+	for _, m := range s.msgs {
+		if err := emit(fakeEvent{m}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func (fakeSource) Fields() []string { return []string{"msg"} }
+func (fakeSource) Renderer() output.Renderer {
+	return output.Renderer{
+		Headers: []string{"Message"},
+		Row:     func(r output.ScanResult) []string { return []string{r.Message} },
+	}
+}
+func (fakeSource) DefaultMapping() string { return "/nonexistent/fake.yml" }
+
+func writeRule(t *testing.T, dir, name, body string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	Register(fakeSource{})
+	defer func() {
+		registryMu.Lock()
+		delete(registry, "fake")
+		registryMu.Unlock()
+	}()
+
+	if _, ok := Lookup("fake"); !ok {
+		t.Fatal("Lookup(fake) should find the registered source")
+	}
+	found := false
+	for _, n := range Names() {
+		if n == "fake" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Names() should include fake, got %v", Names())
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a duplicate source should panic")
+		}
+	}()
+	Register(fakeSource{})
+}
+
+func TestMappedResolvesFieldNames(t *testing.T) {
+	m := &mapping.Mapping{Fields: map[string]string{"Message": "msg"}}
+	e := NewMapped(fakeEvent{"hello"}, m)
+	if v, ok := e.Select("Message"); !ok || v != "hello" {
+		t.Errorf("Select(Message): got %v, ok=%v", v, ok)
+	}
+	if v, ok := e.Select("msg"); !ok || v != "hello" {
+		t.Errorf("Select(msg) passthrough: got %v, ok=%v", v, ok)
+	}
+}
+
+func TestRunEndToEnd(t *testing.T) {
+	rules := t.TempDir()
+	writeRule(t, rules, "evil.yml", `
+title: Evil Message
+id: evil-1
+author: tester
+detection:
+  selection:
+    msg|contains: evil
+  condition: selection
+`)
+
+	src := fakeSource{msgs: []string{"benign", "evil one", "benign", "evil two"}}
+	var buf bytes.Buffer
+	err := run(&buf, src, Options{RulePaths: []string{rules}, OutputType: "json", Workers: 4})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var out []output.ScanResult
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatalf("invalid JSON output: %v\n%s", err, buf.String())
+	}
+	if len(out) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(out))
+	}
+	if out[0].Message != "evil one" || out[1].Message != "evil two" {
+		t.Errorf("results out of order: %q, %q", out[0].Message, out[1].Message)
+	}
+	if out[0].RuleID != "evil-1" || !strings.Contains(out[0].Title, "Evil") {
+		t.Errorf("rule fields not populated: %+v", out[0])
+	}
+}
+
+func TestRunRecordsFilePerResult(t *testing.T) {
+	rules := t.TempDir()
+	writeRule(t, rules, "evil.yml", `
+title: Evil Message
+id: evil-1
+detection:
+  selection:
+    msg|contains: evil
+  condition: selection
+`)
+
+	src := fakeSource{msgs: []string{"evil", "benign"}}
+	var buf bytes.Buffer
+	opts := Options{RulePaths: []string{rules}, OutputType: "csv", Files: []string{"first.log", "second.log"}, Workers: 2}
+	if err := run(&buf, src, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected header + 2 rows, got %q", lines)
+	}
+	if !strings.HasPrefix(lines[0], "File,") {
+		t.Errorf("multi-file CSV should lead with a File column, got %q", lines[0])
+	}
+	if !strings.HasPrefix(lines[1], "first.log,") || !strings.HasPrefix(lines[2], "second.log,") {
+		t.Errorf("rows should record their source file in order, got %q", lines[1:])
+	}
+}
+
+// mergeSource merges its .journal logs, streaming one event per merged log.
+type mergeSource struct{ fakeSource }
+
+func (mergeSource) Mergeable(path string) bool { return strings.HasSuffix(path, ".journal") }
+func (mergeSource) StreamMerged(paths []string, opts Options, emit func(Event) error) error {
+	for _, p := range paths {
+		if err := emit(fakeEvent{"evil " + p}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestRunMergesLogs(t *testing.T) {
+	rules := t.TempDir()
+	writeRule(t, rules, "evil.yml", `
+title: Evil Message
+id: evil-1
+detection:
+  selection:
+    msg|contains: evil
+  condition: selection
+`)
+
+	src := mergeSource{fakeSource{msgs: []string{"evil"}}}
+	var buf bytes.Buffer
+	opts := Options{RulePaths: []string{rules}, OutputType: "csv", Files: []string{"a.log", "b.journal", "c.log", "d.journal"}}
+	if err := run(&buf, src, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	want := []string{
+		"File,Message",
+		"a.log,evil",
+		`"b.journal, d.journal",evil b.journal`,
+		`"b.journal, d.journal",evil d.journal`,
+		"c.log,evil",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("merged logs should be read once, in the place of the first:\n got  %q\n want %q", lines, want)
+	}
+}
@@ -0,0 +1,251 @@
+package chop
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/M00NLIG7/ChopChopGo/maps/input"
+)
+
+// State is the -state checkpoint file, which lets repeated scans of the same
+// logs resume where the previous one stopped instead of reporting its
+// detections again. Files are checkpointed by identity (device and inode)
+// and the offset after the last complete line read; binary journals by the
+// cursor of the last entry read.
+//
+// Checkpoints are recorded as logs are read and written by Save, which
+// should only be called once a scan has succeeded.
+type State struct {
+	path string
+
+	mu sync.Mutex
+	// Files maps a log's path to its checkpoint.
+	Files map[string]FileCheckpoint `json:"files,omitempty"`
+	// Journals maps a journal path, empty for the live journal, to the
+	// cursor of the last entry read.
+	Journals map[string]string `json:"journals,omitempty"`
+
+	// open holds the logs read during this scan; their checkpoints are
+	// taken when Save is called.
+	open map[string]checkpointer
+}
+
+// FileCheckpoint is where the scan of one log file stopped.
+type FileCheckpoint struct {
+	Dev    uint64 `json:"dev"`
+	Inode  uint64 `json:"inode"`
+	Offset int64  `json:"offset"`
+}
+
+// checkpointer is a log being read that can report how far it got.
+type checkpointer interface {
+	Checkpoint() (os.FileInfo, int64)
+}
+
+// LoadState reads the checkpoint file at path. A missing file yields an
+// empty State, so the first scan reads everything and creates it.
+func LoadState(path string) (*State, error) {
+	s := &State{path: path}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, s); err != nil {
+			return nil, fmt.Errorf("parsing state file %s: %w", path, err)
+		}
+	}
+	if s.Files == nil {
+		s.Files = make(map[string]FileCheckpoint)
+	}
+	if s.Journals == nil {
+		s.Journals = make(map[string]string)
+	}
+	s.open = make(map[string]checkpointer)
+	return s, nil
+}
+
+// Save records the checkpoints of the logs read since LoadState and writes
+// the state file. Checkpoints of files that no longer exist are dropped.
+// The file is replaced atomically, so an interrupted Save leaves the
+// previous state intact.
+func (s *State) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for path, c := range s.open {
+		info, offset := c.Checkpoint()
+		dev, ino := fileID(info)
+		s.Files[path] = FileCheckpoint{Dev: dev, Inode: ino, Offset: offset}
+	}
+	for path := range s.Files {
+		if _, err := os.Stat(path); err != nil {
+			delete(s.Files, path)
+		}
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("writing state file: %w", err)
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("writing state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("writing state file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("writing state file: %w", err)
+	}
+	return nil
+}
+
+// Cursor returns the checkpointed cursor of the journal at path, or "".
+func (s *State) Cursor(path string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Journals[path]
+}
+
+// SetCursor checkpoints the journal at path at cursor.
+func (s *State) SetCursor(path, cursor string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Journals[path] = cursor
+}
+
+// offset returns where reading the file described by info should resume.
+// A checkpoint recorded under another path still applies when the identity
+// matches, so lines written to a log just before logrotate renamed it are
+// read from the rotated copy. Without a usable identity, as on Windows, only
+// a checkpoint for the same path is used.
+func (s *State) offset(path string, info os.FileInfo) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dev, ino := fileID(info)
+	if cp, ok := s.Files[path]; ok && cp.Dev == dev && cp.Inode == ino {
+		return cp.Offset
+	}
+	if ino == 0 {
+		return 0
+	}
+	for _, cp := range s.Files {
+		if cp.Dev == dev && cp.Inode == ino {
+			return cp.Offset
+		}
+	}
+	return 0
+}
+
+func (s *State) track(path string, c checkpointer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.open[path] = c
+}
+
+// openLog is OpenLog with checkpointing: the log is read from its
+// checkpoint, if any, and recorded for Save.
+func (s *State) openLog(path string, opts Options) (io.ReadCloser, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	offset := s.offset(path, info)
+
+	if opts.Follow {
+		if offset == 0 && !opts.FollowFromStart() {
+			offset = info.Size()
+		}
+		fl, err := input.FollowAt(path, offset, opts.Stop)
+		if err != nil {
+			return nil, err
+		}
+		s.track(path, fl)
+		return fl, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if info, err = f.Stat(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	head := make([]byte, 8)
+	n, _ := f.ReadAt(head, 0)
+	compressed := input.Detect(head[:n]) != input.None
+	if !compressed {
+		if offset > info.Size() {
+			// Truncated in place since the checkpoint (copytruncate).
+			offset = 0
+		}
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	r, err := input.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	lr := &lineReader{r: r, f: f, info: info, offset: offset, lineEnd: offset}
+	if compressed {
+		// Compressed logs cannot be seeked; skip what was already read.
+		// Rotated logs do not change once compressed, so this only costs
+		// decompression.
+		if _, err := io.CopyN(io.Discard, r, offset); err != nil && err != io.EOF {
+			lr.Close()
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	s.track(path, lr)
+	return lr, nil
+}
+
+// lineReader tracks the offset after the last complete line read from a log,
+// counted in decompressed bytes.
+type lineReader struct {
+	r       io.ReadCloser
+	f       *os.File
+	info    os.FileInfo
+	offset  int64
+	lineEnd int64
+}
+
+func (lr *lineReader) Read(p []byte) (int, error) {
+	n, err := lr.r.Read(p)
+	if i := bytes.LastIndexByte(p[:n], '\n'); i >= 0 {
+		lr.lineEnd = lr.offset + int64(i) + 1
+	}
+	lr.offset += int64(n)
+	return n, err
+}
+
+func (lr *lineReader) Close() error {
+	err := lr.r.Close()
+	if ferr := lr.f.Close(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// Checkpoint satisfies the checkpointer interface.
+func (lr *lineReader) Checkpoint() (os.FileInfo, int64) {
+	return lr.info, lr.lineEnd
+}
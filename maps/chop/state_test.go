@@ -0,0 +1,156 @@
+package chop
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// readLog reads path through OpenLog with the state file at statePath and
+// saves it, as one -state run would.
+func readLog(t *testing.T, statePath, path string) string {
+	t.Helper()
+	state, err := LoadState(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := OpenLog(path, Options{State: state})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.Save(); err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func appendLog(t *testing.T, path, s string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStateResumesAfterLastLine(t *testing.T) {
+	dir := t.TempDir()
+	statePath := filepath.Join(dir, "state.json")
+	log := filepath.Join(dir, "syslog")
+
+	appendLog(t, log, "one\ntw")
+	if got := readLog(t, statePath, log); got != "one\ntw" {
+		t.Fatalf("first run read %q", got)
+	}
+	// The partial line is read again once it is complete.
+	appendLog(t, log, "o\n")
+	if got := readLog(t, statePath, log); got != "two\n" {
+		t.Fatalf("second run read %q, want %q", got, "two\n")
+	}
+	if got := readLog(t, statePath, log); got != "" {
+		t.Fatalf("third run read %q, want nothing", got)
+	}
+}
+
+func TestStateFollowsRotation(t *testing.T) {
+	dir := t.TempDir()
+	statePath := filepath.Join(dir, "state.json")
+	log := filepath.Join(dir, "syslog")
+
+	appendLog(t, log, "one\n")
+	readLog(t, statePath, log)
+	appendLog(t, log, "two\n")
+	if err := os.Rename(log, log+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendLog(t, log, "three\n")
+
+	// The rotated copy resumes from the checkpoint of the file it was.
+	if got := readLog(t, statePath, log+".1"); got != "two\n" {
+		t.Errorf("rotated log read %q, want %q", got, "two\n")
+	}
+	if got := readLog(t, statePath, log); got != "three\n" {
+		t.Errorf("new log read %q, want %q", got, "three\n")
+	}
+}
+
+func TestStateTruncatedLogStartsOver(t *testing.T) {
+	dir := t.TempDir()
+	statePath := filepath.Join(dir, "state.json")
+	log := filepath.Join(dir, "syslog")
+
+	appendLog(t, log, "a long first line\n")
+	readLog(t, statePath, log)
+	if err := os.Truncate(log, 0); err != nil {
+		t.Fatal(err)
+	}
+	appendLog(t, log, "new\n")
+	if got := readLog(t, statePath, log); got != "new\n" {
+		t.Errorf("truncated log read %q, want %q", got, "new\n")
+	}
+}
+
+func TestStateCompressedLog(t *testing.T) {
+	dir := t.TempDir()
+	statePath := filepath.Join(dir, "state.json")
+	log := filepath.Join(dir, "syslog.2.gz")
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte("one\ntwo\n"))
+	zw.Close()
+	if err := os.WriteFile(log, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readLog(t, statePath, log); got != "one\ntwo\n" {
+		t.Fatalf("first run read %q", got)
+	}
+	if got := readLog(t, statePath, log); got != "" {
+		t.Errorf("second run read %q, want nothing", got)
+	}
+}
+
+func TestStateCursorsAndPruning(t *testing.T) {
+	dir := t.TempDir()
+	statePath := filepath.Join(dir, "state.json")
+	log := filepath.Join(dir, "syslog")
+	appendLog(t, log, "one\n")
+	readLog(t, statePath, log)
+
+	state, err := LoadState(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.SetCursor("", "s=abc;i=1")
+	if err := os.Remove(log); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err = LoadState(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := state.Cursor(""); got != "s=abc;i=1" {
+		t.Errorf("expected the live journal cursor to survive a reload, got %q", got)
+	}
+	if _, ok := state.Files[log]; ok {
+		t.Errorf("expected the checkpoint of the removed log to be dropped")
+	}
+}
@@ -0,0 +1,54 @@
+package chop
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeRangeContains(t *testing.T) {
+	since := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	until := time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		r    TimeRange
+		t    time.Time
+		want bool
+	}{
+		{TimeRange{}, since.Add(-time.Hour), true},
+		{TimeRange{Since: since}, since, true},
+		{TimeRange{Since: since}, since.Add(-time.Second), false},
+		{TimeRange{Until: until}, until, true},
+		{TimeRange{Until: until}, until.Add(time.Second), false},
+		{TimeRange{Since: since, Until: until}, since.Add(12 * time.Hour), true},
+	}
+	for _, c := range cases {
+		if got := c.r.Contains(c.t); got != c.want {
+			t.Errorf("%+v.Contains(%v) = %v, want %v", c.r, c.t, got, c.want)
+		}
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	cases := map[string]time.Time{
+		"2024-03-01T08:00:00Z":      time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC),
+		"2024-03-01T08:00:00+02:00": time.Date(2024, 3, 1, 6, 0, 0, 0, time.UTC),
+		"24h":                       now.Add(-24 * time.Hour),
+		"90m":                       now.Add(-90 * time.Minute),
+		"7d":                        now.AddDate(0, 0, -7),
+	}
+	for in, want := range cases {
+		got, err := ParseTime(in, now)
+		if err != nil {
+			t.Errorf("ParseTime(%q): unexpected error: %v", in, err)
+			continue
+		}
+		if !got.Equal(want) {
+			t.Errorf("ParseTime(%q) = %v, want %v", in, got, want)
+		}
+	}
+	for _, bad := range []string{"", "yesterday", "-5h", "2024-03-01"} {
+		if _, err := ParseTime(bad, now); err == nil {
+			t.Errorf("ParseTime(%q) should fail", bad)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package chop
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/M00NLIG7/ChopChopGo/maps/input"
+	"github.com/M00NLIG7/ChopChopGo/maps/mapping"
+	"github.com/M00NLIG7/ChopChopGo/maps/output"
+)
+
+// sniffSize is how much of each decompressed file is handed to Detect.
+const sniffSize = 4096
+
+// Triage scans every log found under root, which is either a directory (for
+// example a collected /var/log tree) or a tar or zip archive such as a UAC
+// triage package. Each file is decompressed if needed, classified by the
+// registered Detector sources and scanned with that source's default mapping.
+// Files no source recognises, or that cannot be read, are skipped with a
+// warning for the latter.
+func Triage(root string, opts Options) error {
+	return triage(os.Stdout, os.Stderr, root, opts)
+}
+
+func triage(w, warn io.Writer, root string, opts Options) error {
+	detectors := Detectors()
+	if len(detectors) == 0 {
+		return fmt.Errorf("no registered source can classify files")
+	}
+
+	s, err := newScan(opts, true)
+	if err != nil {
+		return err
+	}
+
+	mappings := make(map[string]*mapping.Mapping)
+	var scanned, skipped int
+	scanOne := func(name string, modTime time.Time, r io.Reader) {
+		zr, err := input.NewReader(r)
+		if err != nil {
+			fmt.Fprintf(warn, "Warning: skipping %s: %v\n", name, err)
+			skipped++
+			return
+		}
+		defer zr.Close()
+
+		br := bufio.NewReaderSize(zr, sniffSize)
+		head, _ := br.Peek(sniffSize)
+		src := classify(detectors, head)
+		if src == nil {
+			skipped++
+			return
+		}
+		m, ok := mappings[src.Name()]
+		if !ok {
+			m = loadMapping(src, "")
+			mappings[src.Name()] = m
+		}
+
+		scanned++
+		err = src.StreamReader(br, modTime, opts, func(e Event) error {
+			s.submit(e, m, name, src.Name())
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintf(warn, "Warning: %s log %s ended early: %v\n", src.Name(), name, err)
+		}
+	}
+
+	info, err := os.Stat(root)
+	if err != nil {
+		s.close()
+		return err
+	}
+	if info.IsDir() {
+		err = walkDir(os.DirFS(root), root, scanOne, func(name string, err error) {
+			fmt.Fprintf(warn, "Warning: skipping %s: %v\n", name, err)
+			skipped++
+		})
+	} else {
+		err = walkArchive(root, scanOne)
+	}
+	s.close()
+	if err != nil {
+		return err
+	}
+
+	renderer := output.TriageRenderer
+	if opts.Context {
+		renderer = renderer.WithContext()
+	}
+	if err := output.Write(w, opts.OutputType, s.results, renderer); err != nil {
+		return fmt.Errorf("writing output: %w", err)
+	}
+	if opts.ShowProgress() {
+		fmt.Fprintf(w, "Processed %d events from %d file(s); skipped %d unreadable or unrecognised file(s)\n",
+			s.processed, scanned, skipped)
+	}
+	return nil
+}
+
+// Detectors returns the registered sources that implement Detector, in name
+// order so classification is deterministic.
+func Detectors() []Detector {
+	var detectors []Detector
+	for _, name := range Names() {
+		src, _ := Lookup(name)
+		if d, ok := src.(Detector); ok {
+			detectors = append(detectors, d)
+		}
+	}
+	return detectors
+}
+
+// classify returns the first detector recognising head, or nil.
+func classify(detectors []Detector, head []byte) Detector {
+	for _, d := range detectors {
+		if d.Detect(head) {
+			return d
+		}
+	}
+	return nil
+}
+
+// walkFunc receives one file found by a walk together with its modification
+// time as recorded on disk or in the archive.
+type walkFunc func(name string, modTime time.Time, r io.Reader)
+
+// walkDir calls fn for every regular file of fsys, the directory tree at
+// root. A file or directory that cannot be read, such as the root-only btmp
+// or audit/ of a collected /var/log, is reported to skip and the walk goes
+// on; only an unreadable root fails it.
+func walkDir(fsys fs.FS, root string, fn walkFunc, skip func(name string, err error)) error {
+	return fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		name := filepath.Join(root, filepath.FromSlash(p))
+		if err != nil {
+			if p == "." {
+				return err
+			}
+			skip(name, err)
+			if d != nil && d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			skip(name, err)
+			return nil
+		}
+		f, err := fsys.Open(p)
+		if err != nil {
+			skip(name, err)
+			return nil
+		}
+		defer f.Close()
+		fn(name, info.ModTime(), f)
+		return nil
+	})
+}
+
+// walkArchive calls fn for every regular file in the tar or zip archive at
+// path. Tarballs may themselves be compressed (tar.gz, tar.zst, …); members
+// are named archive!member so results point back into the archive.
+func walkArchive(path string, fn walkFunc) error {
+	f, err := input.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	head, _ := br.Peek(512)
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")):
+		return walkZip(path, fn)
+	case len(head) >= 262 && string(head[257:262]) == "ustar":
+		return walkTar(path, br, fn)
+	default:
+		return fmt.Errorf("%s is neither a directory nor a tar or zip archive", path)
+	}
+}
+
+func walkTar(path string, r io.Reader, fn walkFunc) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", path, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		fn(path+"!"+hdr.Name, hdr.ModTime, tr)
+	}
+}
+
+func walkZip(path string, fn walkFunc) error {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+	defer zr.Close()
+	for _, zf := range zr.File {
+		if !zf.Mode().IsRegular() {
+			continue
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return fmt.Errorf("reading %s!%s: %w", path, zf.Name, err)
+		}
+		fn(path+"!"+zf.Name, zf.Modified, rc)
+		rc.Close()
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+package chop
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// lineSource is a Detector for files whose lines start with its prefix; each
+// line becomes one event.
+type lineSource struct {
+	fakeSource
+	name, prefix string
+}
+
+func (s lineSource) Name() string { return s.name }
+func (s lineSource) Detect(head []byte) bool {
+	return bytes.HasPrefix(head, []byte(s.prefix))
+}
+func (s lineSource) StreamReader(r io.Reader, modTime time.Time, opts Options, emit func(Event) error) error {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		if err := emit(fakeEvent{sc.Text()}); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+// withDetectors swaps the registry for the duration of a test so triage only
+// sees the given sources.
+func withDetectors(t *testing.T, srcs ...Source) {
+	t.Helper()
+	registryMu.Lock()
+	saved := registry
+	registry = make(map[string]Source)
+	registryMu.Unlock()
+	for _, s := range srcs {
+		Register(s)
+	}
+	t.Cleanup(func() {
+		registryMu.Lock()
+		registry = saved
+		registryMu.Unlock()
+	})
+}
+
+func gz(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(s))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+var triageFiles = map[string]func(t *testing.T) []byte{
+	"log/alpha.log":     func(*testing.T) []byte { return []byte("ALPHA evil one\nALPHA benign\n") },
+	"log/beta.log.1.gz": func(t *testing.T) []byte { return gz(t, "BETA evil two\n") },
+	"log/wtmp":          func(*testing.T) []byte { return []byte{0x00, 0x01, 0x02, 'e', 'v', 'i', 'l'} },
+}
+
+func triageRules(t *testing.T) string {
+	rules := t.TempDir()
+	writeRule(t, rules, "evil.yml", `
+title: Evil Message
+id: evil-1
+detection:
+  selection:
+    msg|contains: evil
+  condition: selection
+`)
+	return rules
+}
+
+func runTriage(t *testing.T, root string) (string, string) {
+	t.Helper()
+	withDetectors(t,
+		lineSource{name: "alpha", prefix: "ALPHA"},
+		lineSource{name: "beta", prefix: "BETA"},
+		fakeSource{}, // not a Detector: must be ignored
+	)
+	var out, warn bytes.Buffer
+	if err := triage(&out, &warn, root, Options{RulePaths: []string{triageRules(t)}, OutputType: "csv"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return out.String(), warn.String()
+}
+
+func checkTriageRows(t *testing.T, out string) {
+	t.Helper()
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected header + 2 rows, got %q", lines)
+	}
+	joined := strings.Join(lines[1:], "\n")
+	for _, want := range []string{"alpha.log,alpha,", "beta.log.1.gz,beta,"} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("missing row containing %q in:\n%s", want, joined)
+		}
+	}
+	if strings.Contains(joined, "wtmp") {
+		t.Errorf("unrecognised binary file should be skipped:\n%s", joined)
+	}
+}
+
+func TestTriageDirectory(t *testing.T) {
+	root := t.TempDir()
+	for name, data := range triageFiles {
+		path := filepath.Join(root, name)
+		os.MkdirAll(filepath.Dir(path), 0700)
+		if err := os.WriteFile(path, data(t), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out, _ := runTriage(t, root)
+	checkTriageRows(t, out)
+}
+
+// deniedFS is a directory tree in which some paths cannot be opened, like
+// the root-only files of a collected /var/log seen by a normal user.
+type deniedFS struct {
+	fstest.MapFS
+	denied map[string]bool
+}
+
+func (f deniedFS) Open(name string) (fs.File, error) {
+	if f.denied[name] {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	return f.MapFS.Open(name)
+}
+
+func (f deniedFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if f.denied[name] {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	return f.MapFS.ReadDir(name)
+}
+
+func TestWalkDirSkipsUnreadableEntries(t *testing.T) {
+	fsys := deniedFS{
+		MapFS: fstest.MapFS{
+			"auth.log":        {Data: []byte("ALPHA\n")},
+			"btmp":            {Data: []byte{0}},
+			"audit/audit.log": {Data: []byte("type=SYSCALL\n")},
+			"syslog":          {Data: []byte("ALPHA\n")},
+		},
+		denied: map[string]bool{"btmp": true, "audit": true},
+	}
+	var visited, skipped []string
+	err := walkDir(fsys, "/evidence/log", func(name string, _ time.Time, _ io.Reader) {
+		visited = append(visited, name)
+	}, func(name string, err error) {
+		if !errors.Is(err, fs.ErrPermission) {
+			t.Errorf("%s: unexpected error %v", name, err)
+		}
+		skipped = append(skipped, name)
+	})
+	if err != nil {
+		t.Fatalf("an unreadable entry should not end the walk: %v", err)
+	}
+	if got, want := strings.Join(visited, ","), filepath.FromSlash("/evidence/log/auth.log,/evidence/log/syslog"); got != want {
+		t.Errorf("visited %s, want %s", got, want)
+	}
+	if got, want := strings.Join(skipped, ","), filepath.FromSlash("/evidence/log/audit,/evidence/log/btmp"); got != want {
+		t.Errorf("skipped %s, want %s", got, want)
+	}
+}
+
+func TestTriageTarGz(t *testing.T) {
+	var tarBuf bytes.Buffer
+	tw := tar.NewWriter(&tarBuf)
+	for name, data := range triageFiles {
+		b := data(t)
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(b)), Typeflag: tar.TypeReg})
+		tw.Write(b)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "triage.tar.gz")
+	if err := os.WriteFile(path, gz(t, tarBuf.String()), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	out, _ := runTriage(t, path)
+	checkTriageRows(t, out)
+	if !strings.Contains(out, "triage.tar.gz!log/alpha.log") {
+		t.Errorf("archive members should be named archive!member, got:\n%s", out)
+	}
+}
+
+func TestTriageZip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "triage.zip")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for name, data := range triageFiles {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data(t))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	out, _ := runTriage(t, path)
+	checkTriageRows(t, out)
+}
+
+func TestTriageRejectsPlainFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plain.log")
+	if err := os.WriteFile(path, []byte("ALPHA not an archive\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	withDetectors(t, lineSource{name: "alpha", prefix: "ALPHA"})
+	var out, warn bytes.Buffer
+	if err := triage(&out, &warn, path, Options{RulePaths: []string{triageRules(t)}, OutputType: "csv"}); err == nil {
+		t.Error("expected error for a plain file that is not an archive")
+	}
+}
@@ -0,0 +1,112 @@
+package chop
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/M00NLIG7/ChopChopGo/maps/rules"
+)
+
+// Validate loads the rules in opts.RulePaths the way a scan would and writes a
+// report to w: one line per rule file that loaded cleanly, failed or uses
+// features this tool does not implement, plus a warning for every field a
+// rule reads that src's events cannot provide after applying the -mapping
+// or src's default mapping; for a source implementing OpenFields, every name
+// it accepts counts as provided. Rules whose logsource does not select src are
+// listed as skipped and not checked for fields. It reports whether the rules
+// passed, which with strict also requires no unsupported rules and no field
+// warnings.
+func Validate(w io.Writer, src Source, opts Options, strict bool) (bool, error) {
+	set, err := rules.Load(opts.ruleConfig())
+	if err != nil {
+		return false, fmt.Errorf("loading ruleset: %w", err)
+	}
+	m := loadMapping(src, opts.MappingPath)
+	native := make(map[string]bool)
+	for _, f := range src.Fields() {
+		native[f] = true
+	}
+	known := func(field string) bool { return native[field] }
+	if open, ok := src.(OpenFields); ok {
+		known = func(field string) bool { return native[field] || open.HasField(field) }
+	}
+
+	type fileReport struct {
+		loaded   int
+		skipped  int
+		problems []rules.Problem
+		warnings []string
+	}
+	files := make(map[string]*fileReport)
+	report := func(path string) *fileReport {
+		if files[path] == nil {
+			files[path] = &fileReport{}
+		}
+		return files[path]
+	}
+	warnings, applied := 0, 0
+	for _, r := range set.Rules {
+		fr := report(r.Path)
+		fr.loaded++
+		if !set.Applies(src.Name(), r) {
+			fr.skipped++
+			continue
+		}
+		applied++
+		seen := make(map[string]bool)
+		for _, f := range r.Fields {
+			if seen[f] {
+				continue
+			}
+			seen[f] = true
+			if resolved := m.Resolve(f); !known(resolved) {
+				fr.warnings = append(fr.warnings, fmt.Sprintf("%q: field %s does not resolve to a field of the %s target", r.Title, f, src.Name()))
+				warnings++
+			}
+		}
+	}
+	for _, p := range set.Problems {
+		fr := report(p.Path)
+		fr.problems = append(fr.problems, p)
+	}
+
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		fr := files[path]
+		if len(fr.problems) == 0 && len(fr.warnings) == 0 {
+			if fr.skipped == fr.loaded {
+				fmt.Fprintf(w, "SKIP         %s: logsource does not select %s\n", path, src.Name())
+			} else {
+				fmt.Fprintf(w, "OK           %s\n", path)
+			}
+			continue
+		}
+		for _, p := range fr.problems {
+			status := "FAIL"
+			if p.Unsupported {
+				status = "UNSUPPORTED"
+			}
+			if p.Title != "" {
+				fmt.Fprintf(w, "%-12s %s: %q: %v\n", status, path, p.Title, p.Err)
+			} else {
+				fmt.Fprintf(w, "%-12s %s: %v\n", status, path, p.Err)
+			}
+		}
+		for _, warning := range fr.warnings {
+			fmt.Fprintf(w, "%-12s %s: %s\n", "WARN", path, warning)
+		}
+	}
+	fmt.Fprintf(w, "%d rule file(s): %d rule(s) loaded, %d failed, %d unsupported; %d apply to %s with %d field warning(s)\n",
+		set.Total, set.Ok, set.Failed, set.Unsupported, applied, src.Name(), warnings)
+
+	ok := set.Failed == 0
+	if strict {
+		ok = ok && set.Unsupported == 0 && warnings == 0
+	}
+	return ok, nil
+}
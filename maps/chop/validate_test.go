@@ -0,0 +1,104 @@
+package chop
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	writeRule(t, dir, "ok.yml", `
+title: Known Field
+detection:
+  sel:
+    msg|contains: hello
+  condition: sel
+`)
+	writeRule(t, dir, "unknown.yml", `
+title: Unknown Field
+detection:
+  sel:
+    Image|endswith: /sh
+  condition: sel
+`)
+	writeRule(t, dir, "b64.yml", `
+title: Encoded
+detection:
+  sel:
+    msg|contains: curl
+  condition: sel | sum(bytes) > 1000
+`)
+
+	var buf bytes.Buffer
+	ok, err := Validate(&buf, fakeSource{}, Options{RulePaths: []string{dir}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if !ok {
+		t.Errorf("expected unsupported rules and warnings to pass without -strict:\n%s", out)
+	}
+	for _, want := range []string{
+		"OK           " + dir + "/ok.yml",
+		"UNSUPPORTED  " + dir + "/b64.yml",
+		"WARN         " + dir + "/unknown.yml: \"Unknown Field\": field Image",
+		"3 rule file(s): 2 rule(s) loaded, 0 failed, 1 unsupported; 2 apply to fake with 1 field warning(s)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("report is missing %q:\n%s", want, out)
+		}
+	}
+
+	if ok, _ := Validate(&bytes.Buffer{}, fakeSource{}, Options{RulePaths: []string{dir}}, true); ok {
+		t.Errorf("expected -strict to fail on unsupported rules and warnings")
+	}
+}
+
+func TestValidateFailsOnBrokenRule(t *testing.T) {
+	dir := t.TempDir()
+	writeRule(t, dir, "broken.yml", `
+title: Broken
+detection:
+  sel:
+    msg: x
+  condition: sel and missing
+`)
+	var buf bytes.Buffer
+	ok, err := Validate(&buf, fakeSource{}, Options{RulePaths: []string{dir}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok || !strings.Contains(buf.String(), "FAIL         "+dir+"/broken.yml") {
+		t.Errorf("expected the broken rule to fail validation:\n%s", buf.String())
+	}
+}
+
+// openSource accepts any lowercase field, like a source whose events carry
+// arbitrary key=value pairs.
+type openSource struct{ fakeSource }
+
+func (openSource) HasField(name string) bool { return name == strings.ToLower(name) }
+
+func TestValidateOpenFields(t *testing.T) {
+	dir := t.TempDir()
+	writeRule(t, dir, "open.yml", `
+title: Open Field
+detection:
+  sel:
+    a4: "-c"
+    Image|endswith: /sh
+  condition: sel
+`)
+	var buf bytes.Buffer
+	if _, err := Validate(&buf, openSource{}, Options{RulePaths: []string{dir}}, true); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if strings.Contains(out, "field a4") {
+		t.Errorf("a field the source accepts should not be warned about:\n%s", out)
+	}
+	if !strings.Contains(out, "field Image") {
+		t.Errorf("a field the source rejects should still be warned about:\n%s", out)
+	}
+}
@@ -0,0 +1,152 @@
+package input
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"time"
+)
+
+// DefaultPoll is how often a Follower checks a quiet file for new data.
+const DefaultPoll = 250 * time.Millisecond
+
+// Follower reads a growing log file like tail -F. At the end of the file it
+// waits for more data instead of returning io.EOF; when logrotate renames or
+// recreates the path it finishes the old file and continues with the new one
+// from its start, and when the file is truncated in place (copytruncate) it
+// starts over. Followed files are read as-is: compressed logs do not grow.
+type Follower struct {
+	// Idle, when set, is called each time the follower has caught up with
+	// the file, before it waits for more data. Parsers that hold records
+	// back, such as auditd's event grouping, use it to flush. A non-nil
+	// error is returned from Read.
+	Idle func() error
+	// Poll is the interval between checks for new data.
+	Poll time.Duration
+
+	path   string
+	f      *os.File
+	info   os.FileInfo
+	offset int64
+	// lineEnd is the offset just past the last newline read.
+	lineEnd int64
+	stop    <-chan struct{}
+}
+
+// Follow opens path for tailing. fromStart selects whether existing content
+// is read first or skipped. Read returns io.EOF once stop is closed; a nil
+// stop follows forever.
+func Follow(path string, fromStart bool, stop <-chan struct{}) (*Follower, error) {
+	if fromStart {
+		return FollowAt(path, 0, stop)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return FollowAt(path, info.Size(), stop)
+}
+
+// FollowAt is Follow starting at offset, such as a checkpoint saved from an
+// earlier Checkpoint. An offset past the end of the file, which has been
+// truncated since, starts over at its beginning.
+func FollowAt(path string, offset int64, stop <-chan struct{}) (*Follower, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if offset > info.Size() {
+		offset = 0
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &Follower{Poll: DefaultPoll, path: path, f: f, info: info, offset: offset, lineEnd: offset, stop: stop}, nil
+}
+
+// Checkpoint returns the file being followed and the offset just past the
+// last complete line read from it, where a later FollowAt can resume without
+// splitting a line.
+func (fl *Follower) Checkpoint() (os.FileInfo, int64) {
+	return fl.info, fl.lineEnd
+}
+
+// Read satisfies io.Reader, blocking until data is available or stop is
+// closed.
+func (fl *Follower) Read(p []byte) (int, error) {
+	for {
+		n, err := fl.f.Read(p)
+		if n > 0 {
+			if i := bytes.LastIndexByte(p[:n], '\n'); i >= 0 {
+				fl.lineEnd = fl.offset + int64(i) + 1
+			}
+			fl.offset += int64(n)
+			return n, nil
+		}
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+
+		switched, err := fl.reopen()
+		if err != nil {
+			return 0, err
+		}
+		if switched {
+			continue
+		}
+		if fl.Idle != nil {
+			if err := fl.Idle(); err != nil {
+				return 0, err
+			}
+		}
+		select {
+		case <-fl.stop:
+			return 0, io.EOF
+		case <-time.After(fl.Poll):
+		}
+	}
+}
+
+// reopen handles rotation and truncation once the current file is drained.
+// It reports whether reading should resume immediately.
+func (fl *Follower) reopen() (bool, error) {
+	info, err := os.Stat(fl.path)
+	if err != nil {
+		// Between logrotate's rename and the daemon recreating the file
+		// the path may briefly not exist; keep waiting.
+		return false, nil
+	}
+	if !os.SameFile(info, fl.info) {
+		// The writer may have appended to the old file after our last read
+		// but before it was rotated away; finish it first.
+		if old, err := fl.f.Stat(); err == nil && old.Size() > fl.offset {
+			return true, nil
+		}
+		f, err := os.Open(fl.path)
+		if err != nil {
+			return false, nil
+		}
+		fl.f.Close()
+		fl.f, fl.info, fl.offset, fl.lineEnd = f, info, 0, 0
+		return true, nil
+	}
+	if info.Size() < fl.offset {
+		if _, err := fl.f.Seek(0, io.SeekStart); err != nil {
+			return false, err
+		}
+		fl.offset, fl.lineEnd = 0, 0
+		return true, nil
+	}
+	return false, nil
+}
+
+// Close releases the file being followed.
+func (fl *Follower) Close() error {
+	return fl.f.Close()
+}
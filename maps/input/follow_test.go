@@ -0,0 +1,141 @@
+package input
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// followTestPoll keeps the tests fast while leaving the writer time to act.
+const followTestPoll = 5 * time.Millisecond
+
+func newFollower(t *testing.T, path string, fromStart bool, stop chan struct{}) *Follower {
+	t.Helper()
+	fl, err := Follow(path, fromStart, stop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fl.Poll = followTestPoll
+	t.Cleanup(func() { fl.Close() })
+	return fl
+}
+
+func appendFile(t *testing.T, path, s string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readString reads exactly len(want) bytes from fl and compares them.
+func readString(t *testing.T, fl *Follower, want string) {
+	t.Helper()
+	buf := make([]byte, len(want))
+	if _, err := io.ReadFull(fl, buf); err != nil {
+		t.Fatalf("reading %q: %v", want, err)
+	}
+	if string(buf) != want {
+		t.Fatalf("read %q, want %q", buf, want)
+	}
+}
+
+func TestFollowSkipsExistingContent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "syslog")
+	appendFile(t, path, "old\n")
+	fl := newFollower(t, path, false, nil)
+
+	appendFile(t, path, sample)
+	readString(t, fl, sample)
+}
+
+func TestFollowFromStart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "syslog")
+	appendFile(t, path, "old\n")
+	fl := newFollower(t, path, true, nil)
+
+	readString(t, fl, "old\n")
+	appendFile(t, path, sample)
+	readString(t, fl, sample)
+}
+
+func TestFollowRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "syslog")
+	appendFile(t, path, "")
+	fl := newFollower(t, path, false, nil)
+
+	idle := make(chan struct{}, 1)
+	fl.Idle = func() error {
+		select {
+		case idle <- struct{}{}:
+		default:
+		}
+		return nil
+	}
+	go func() {
+		<-idle
+		// The last line reaches the old file after the follower caught up,
+		// then logrotate renames it and the daemon starts a new file.
+		appendFile(t, path, "last\n")
+		if err := os.Rename(path, path+".1"); err != nil {
+			t.Error(err)
+		}
+		appendFile(t, path, "first\n")
+	}()
+	readString(t, fl, "last\nfirst\n")
+}
+
+func TestFollowTruncation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "syslog")
+	appendFile(t, path, "")
+	fl := newFollower(t, path, false, nil)
+
+	appendFile(t, path, "before truncation\n")
+	readString(t, fl, "before truncation\n")
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path, "after\n")
+	readString(t, fl, "after\n")
+}
+
+func TestFollowStop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "syslog")
+	appendFile(t, path, "")
+	stop := make(chan struct{})
+	fl := newFollower(t, path, false, stop)
+
+	close(stop)
+	if _, err := fl.Read(make([]byte, 16)); err != io.EOF {
+		t.Fatalf("expected io.EOF after stop, got %v", err)
+	}
+}
+
+func TestFollowAtCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "syslog")
+	appendFile(t, path, "one\ntwo\n")
+	fl := newFollower(t, path, true, nil)
+
+	readString(t, fl, "one\ntwo\n")
+	appendFile(t, path, "thr")
+	readString(t, fl, "thr")
+	if _, offset := fl.Checkpoint(); offset != 8 {
+		t.Fatalf("expected the checkpoint after the last complete line (8), got %d", offset)
+	}
+
+	resumed, err := FollowAt(path, 8, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resumed.Close()
+	resumed.Poll = followTestPoll
+	appendFile(t, path, "ee\n")
+	readString(t, resumed, "three\n")
+}
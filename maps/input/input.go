@@ -0,0 +1,123 @@
+// Package input opens log files for the file-based sources, transparently
+// decompressing the formats logrotate and evidence collectors commonly produce.
+//
+// Compression is detected from the leading magic bytes rather than the file
+// extension, so renamed or extension-less archives (audit.log.1 that is really
+// zstd, for example) are still read correctly.
+package input
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Compression identifies the container format of a log stream.
+type Compression string
+
+const (
+	None  Compression = "none"
+	Gzip  Compression = "gzip"
+	Bzip2 Compression = "bzip2"
+	XZ    Compression = "xz"
+	Zstd  Compression = "zstd"
+)
+
+var magics = []struct {
+	c     Compression
+	magic []byte
+}{
+	{Gzip, []byte{0x1f, 0x8b}},
+	{Bzip2, []byte("BZh")},
+	{XZ, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	{Zstd, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+}
+
+// maxMagic is the longest magic number in magics.
+const maxMagic = 6
+
+// Detect reports the compression format indicated by the leading bytes of a
+// stream. Fewer than maxMagic bytes are fine; anything unrecognised is None.
+func Detect(head []byte) Compression {
+	for _, m := range magics {
+		if bytes.HasPrefix(head, m.magic) {
+			return m.c
+		}
+	}
+	return None
+}
+
+// Open opens path and returns a reader over its decompressed contents.
+// Plain-text files are returned as-is.
+func Open(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &readCloser{Reader: r, closers: []io.Closer{r, f}}, nil
+}
+
+// NewReader wraps r with the decompressor matching its magic bytes. The
+// returned ReadCloser releases decompressor resources but does not close r.
+func NewReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	// Peek returns what is available together with io.EOF for short streams,
+	// which is fine: a stream shorter than any magic is simply uncompressed.
+	head, err := br.Peek(maxMagic)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch Detect(head) {
+	case Gzip:
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("opening gzip stream: %w", err)
+		}
+		return zr, nil
+	case Bzip2:
+		return io.NopCloser(bzip2.NewReader(br)), nil
+	case XZ:
+		xr, err := xz.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("opening xz stream: %w", err)
+		}
+		return io.NopCloser(xr), nil
+	case Zstd:
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("opening zstd stream: %w", err)
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return io.NopCloser(br), nil
+	}
+}
+
+// readCloser closes the decompressor and then the underlying file.
+type readCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (rc *readCloser) Close() error {
+	var first error
+	for _, c := range rc.closers {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,136 @@
+package input
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+const testdataDir = "../../testdata"
+
+const sample = "Mar  1 10:00:01 host sshd[1]: Accepted password for root\n"
+
+func gzipBytes(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func xzBytes(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := xz.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zstdBytes(t *testing.T, s string) []byte {
+	t.Helper()
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	return enc.EncodeAll([]byte(s), nil)
+}
+
+func readAll(t *testing.T, path string) string {
+	t.Helper()
+	rc, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open(%s): %v", path, err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("reading %s: %v", path, err)
+	}
+	return string(data)
+}
+
+func TestOpenDecompresses(t *testing.T) {
+	dir := t.TempDir()
+	cases := []struct {
+		name string
+		data []byte
+		want Compression
+	}{
+		{"plain.log", []byte(sample), None},
+		{"syslog.2.gz", gzipBytes(t, sample), Gzip},
+		{"messages.xz", xzBytes(t, sample), XZ},
+		// No telling extension: detection must rely on magic bytes.
+		{"audit.log.1", zstdBytes(t, sample), Zstd},
+	}
+	for _, c := range cases {
+		if got := Detect(c.data); got != c.want {
+			t.Errorf("%s: Detect got %s, want %s", c.name, got, c.want)
+		}
+		path := filepath.Join(dir, c.name)
+		if err := os.WriteFile(path, c.data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if got := readAll(t, path); got != sample {
+			t.Errorf("%s: got %q, want %q", c.name, got, sample)
+		}
+	}
+}
+
+func TestOpenBzip2Fixture(t *testing.T) {
+	plain, err := os.ReadFile(filepath.Join(testdataDir, "syslog.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, filepath.Join(testdataDir, "syslog.log.bz2")); got != string(plain) {
+		t.Errorf("bzip2 fixture did not round-trip:\n%q\n%q", got, plain)
+	}
+}
+
+func TestOpenShortAndEmptyFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, content := range []string{"", "x", "\x1f"} {
+		path := filepath.Join(dir, "short.log")
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if got := readAll(t, path); got != content {
+			t.Errorf("short file %q read back as %q", content, got)
+		}
+	}
+}
+
+func TestOpenCorruptGzip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bad.gz")
+	if err := os.WriteFile(path, []byte{0x1f, 0x8b, 0x00, 0x00}, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path); err == nil {
+		t.Error("expected error for a truncated gzip header")
+	}
+}
+
+func TestOpenMissingFile(t *testing.T) {
+	if _, err := Open("/nonexistent/log.gz"); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
@@ -0,0 +1,71 @@
+package journald
+
+import (
+	"time"
+
+	"github.com/M00NLIG7/ChopChopGo/maps/output"
+)
+
+// JournaldEvent represents a single entry from the systemd journal.
+type JournaldEvent struct {
+	Message   string
+	Timestamp string
+	// Fields holds every field of the entry under its journal name, such as
+	// _SYSTEMD_UNIT, _COMM, _EXE, _PID, _UID, SYSLOG_IDENTIFIER, PRIORITY and
+	// _HOSTNAME, including MESSAGE itself.
+	Fields map[string]string
+}
+
+// Keywords satisfies the sigma.Event interface.
+func (e JournaldEvent) Keywords() ([]string, bool) {
+	return []string{e.Message}, true
+}
+
+// Select satisfies the sigma.Event interface. Besides "message" and
+// "timestamp", any journal field can be selected by its exact name.
+func (e JournaldEvent) Select(name string) (interface{}, bool) {
+	switch name {
+	case "message":
+		return e.Message, true
+	case "timestamp":
+		return e.Timestamp, true
+	default:
+		if v, ok := e.Fields[name]; ok {
+			return v, true
+		}
+		return nil, false
+	}
+}
+
+// newEvent builds a JournaldEvent from an entry's fields and its realtime
+// timestamp in microseconds since the epoch; zero leaves Timestamp empty.
+func newEvent(fields map[string]string, usec uint64) JournaldEvent {
+	e := JournaldEvent{Message: fields["MESSAGE"], Fields: fields}
+	if usec != 0 {
+		e.Timestamp = usecTime(usec).Format(time.RFC3339)
+	}
+	return e
+}
+
+func usecTime(usec uint64) time.Time {
+	return time.Unix(0, int64(usec)*int64(time.Microsecond)).UTC()
+}
+
+var journaldRenderer = output.Renderer{
+	Headers: []string{"Timestamp", "Host", "User", "Exe", "PID", "Message", "Title", "Tags", "Author"},
+	Row: func(r output.ScanResult) []string {
+		return []string{r.Timestamp, r.Host, r.User, r.Exe, r.PID, r.Message, r.Title, output.TagString(r.Tags), r.Author}
+	},
+}
+
+// Result satisfies the chop.Event interface.
+func (e JournaldEvent) Result() output.ScanResult {
+	return output.ScanResult{
+		Timestamp: e.Timestamp,
+		Host:      e.Fields["_HOSTNAME"],
+		User:      e.Fields["_UID"],
+		Exe:       e.Fields["_EXE"],
+		PID:       e.Fields["_PID"],
+		Message:   e.Message,
+	}
+}
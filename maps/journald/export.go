@@ -0,0 +1,227 @@
+package journald
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/M00NLIG7/ChopChopGo/maps/chop"
+)
+
+// maxFieldSize bounds a single binary field in the export format, so a
+// corrupt length prefix fails cleanly instead of exhausting memory.
+const maxFieldSize = 64 << 20
+
+// StreamExportFile is StreamExport for a dump on disk. Dumps compressed
+// with gzip, bzip2, xz or zstd are decompressed on the fly; with opts.Follow
+// the dump is tailed as it grows, see chop.OpenLog.
+func StreamExportFile(path string, opts chop.Options, fn func(JournaldEvent) error) error {
+	f, err := chop.OpenLog(path, opts)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return StreamExport(f, opts, fn)
+}
+
+// StreamExport parses a journal dump made with journalctl -o export or
+// -o json and calls fn once per entry in dump order. The format is detected
+// from the first byte: JSON dumps hold one object per line. It needs no
+// systemd libraries, so it works on any platform.
+//
+// Fields that occur more than once in an entry keep their first value, and
+// the timestamp comes from __REALTIME_TIMESTAMP; entries without one are
+// kept but cannot be filtered by opts.Range. The -unit, -priority, -boot and
+// -after-cursor filters are
+// applied entry by entry, except boot offsets, which need the boot list only
+// a binary journal provides.
+func StreamExport(r io.Reader, opts chop.Options, fn func(JournaldEvent) error) error {
+	f, err := parseFilter(config(opts).Filter)
+	if err != nil {
+		return err
+	}
+	if f.byOffset {
+		return fmt.Errorf("-boot offsets need a binary journal; pass the boot ID (_BOOT_ID) instead")
+	}
+
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.Peek(1)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if b[0] != ' ' && b[0] != '\n' && b[0] != '\r' && b[0] != '\t' {
+			break
+		}
+		br.ReadByte()
+	}
+
+	// started turns true once the -after-cursor entry has gone by.
+	started := f.afterCursor == ""
+	emit := func(fields map[string]string) error {
+		if !started {
+			started = fields["__CURSOR"] == f.afterCursor
+			return nil
+		}
+		usec, _ := strconv.ParseUint(fields["__REALTIME_TIMESTAMP"], 10, 64)
+		if usec != 0 && !opts.Range.Contains(usecTime(usec)) || !f.matches(fields) {
+			return nil
+		}
+		return fn(newEvent(fields, usec))
+	}
+
+	if b, _ := br.Peek(1); b[0] == '{' {
+		err = readJSON(br, emit)
+	} else {
+		err = readExport(br, emit)
+	}
+	if err == nil && !started {
+		err = fmt.Errorf("cursor %q not found in journal dump", f.afterCursor)
+	}
+	return err
+}
+
+// readExport parses the journal export format: entries are separated by an
+// empty line and each field is either "NAME=value\n" or, for values that are
+// not plain text, "NAME\n" followed by a 64-bit little-endian length, the
+// raw value and "\n".
+func readExport(br *bufio.Reader, emit func(map[string]string) error) error {
+	fields := make(map[string]string)
+	flush := func() error {
+		if len(fields) == 0 {
+			return nil
+		}
+		err := emit(fields)
+		fields = make(map[string]string)
+		return err
+	}
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if err == io.EOF && line == "" {
+			return flush()
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch i := strings.IndexByte(line, '='); {
+		case line == "":
+			if err := flush(); err != nil {
+				return err
+			}
+		case i >= 0:
+			setField(fields, line[:i], line[i+1:])
+		default:
+			value, err := readBinaryField(br)
+			if err != nil {
+				return fmt.Errorf("reading field %s: %w", line, err)
+			}
+			setField(fields, line, value)
+		}
+		if err == io.EOF {
+			return flush()
+		}
+	}
+}
+
+func readBinaryField(br *bufio.Reader) (string, error) {
+	var size uint64
+	if err := binary.Read(br, binary.LittleEndian, &size); err != nil {
+		return "", err
+	}
+	if size > maxFieldSize {
+		return "", fmt.Errorf("field of %d bytes exceeds the %d byte limit", size, maxFieldSize)
+	}
+	data := make([]byte, size+1)
+	if _, err := io.ReadFull(br, data); err != nil {
+		return "", err
+	}
+	if data[size] != '\n' {
+		return "", fmt.Errorf("binary field not terminated by a newline")
+	}
+	return string(data[:size]), nil
+}
+
+// readJSON parses journalctl -o json output. Values are strings, null, an
+// array of byte values for non-text data, or an array of those when a field
+// occurs more than once.
+func readJSON(br *bufio.Reader, emit func(map[string]string) error) error {
+	dec := json.NewDecoder(br)
+	for {
+		var raw map[string]json.RawMessage
+		if err := dec.Decode(&raw); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("decoding JSON entry: %w", err)
+		}
+		fields := make(map[string]string, len(raw))
+		for name, value := range raw {
+			if v, ok := jsonValue(value); ok {
+				fields[name] = v
+			}
+		}
+		if err := emit(fields); err != nil {
+			return err
+		}
+	}
+}
+
+// jsonValue decodes one field value, keeping the first of repeated values.
+func jsonValue(raw json.RawMessage) (string, bool) {
+	if string(raw) == "null" {
+		// journalctl prints null for values too large to show.
+		return "", false
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, true
+	}
+	var bytesValue []byte
+	var numbers []int
+	if err := json.Unmarshal(raw, &numbers); err == nil {
+		for _, n := range numbers {
+			bytesValue = append(bytesValue, byte(n))
+		}
+		return string(bytesValue), true
+	}
+	var values []json.RawMessage
+	if err := json.Unmarshal(raw, &values); err == nil && len(values) > 0 {
+		return jsonValue(values[0])
+	}
+	return "", false
+}
+
+func setField(fields map[string]string, name, value string) {
+	if _, exists := fields[name]; !exists {
+		fields[name] = value
+	}
+}
+
+// Detect reports whether head, the start of a decompressed file, is a
+// journalctl -o export or -o json dump. Both begin every entry with the
+// __CURSOR field unless it was filtered out, in which case the realtime
+// timestamp leads.
+func Detect(head []byte) bool {
+	head = bytes.TrimLeft(head, " \t\r\n")
+	for _, lead := range []string{"__CURSOR=", "__REALTIME_TIMESTAMP="} {
+		if bytes.HasPrefix(head, []byte(lead)) {
+			return true
+		}
+	}
+	if !bytes.HasPrefix(head, []byte("{")) {
+		return false
+	}
+	line := head
+	if i := bytes.IndexByte(head, '\n'); i >= 0 {
+		line = head[:i]
+	}
+	return bytes.Contains(line, []byte(`"__CURSOR"`)) || bytes.Contains(line, []byte(`"__REALTIME_TIMESTAMP"`))
+}
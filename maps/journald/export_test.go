@@ -0,0 +1,138 @@
+package journald
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/M00NLIG7/ChopChopGo/maps/chop"
+)
+
+const testdataDir = "../../testdata"
+
+func TestStreamExportFormats(t *testing.T) {
+	for _, name := range []string{"journal.export", "journal.json"} {
+		t.Run(name, func(t *testing.T) {
+			events, err := ParseEvents(filepath.Join(testdataDir, name))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(events) != 3 {
+				t.Fatalf("expected 3 events, got %d", len(events))
+			}
+
+			first := events[0]
+			if first.Message != "Accepted publickey for root from 10.0.0.5" || first.Timestamp != "2023-03-01T10:00:00Z" {
+				t.Errorf("unexpected first event: %q at %q", first.Message, first.Timestamp)
+			}
+			for field, want := range map[string]string{
+				"_SYSTEMD_UNIT":     "ssh.service",
+				"_EXE":              "/usr/sbin/sshd",
+				"SYSLOG_IDENTIFIER": "sshd",
+				"__CURSOR":          "s=1;i=1",
+			} {
+				if v, ok := first.Select(field); !ok || v != want {
+					t.Errorf("Select(%q) = %v, %v; want %q", field, v, ok, want)
+				}
+			}
+
+			// Binary (multi-line) values are decoded and the first of
+			// repeated fields wins.
+			if got := events[1].Message; got != "line one\nline two" {
+				t.Errorf("binary MESSAGE: got %q", got)
+			}
+			if _, ok := events[1].Fields["_CMDLINE"]; ok {
+				t.Error("null values should be treated as absent")
+			}
+		})
+	}
+}
+
+func TestStreamExportTimeRangeAndStop(t *testing.T) {
+	rng := chop.TimeRange{Since: time.Date(2023, 3, 1, 10, 30, 0, 0, time.UTC)}
+	var got []string
+	err := StreamExportFile(filepath.Join(testdataDir, "journal.export"), chop.Options{Range: rng}, func(e JournaldEvent) error {
+		got = append(got, e.Fields["_COMM"])
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(got, ",") != "bash,cron" {
+		t.Errorf("expected the two events after 10:30, got %v", got)
+	}
+
+	stop := errors.New("stop")
+	err = StreamExportFile(filepath.Join(testdataDir, "journal.json"), chop.Options{}, func(JournaldEvent) error {
+		return stop
+	})
+	if err != stop {
+		t.Errorf("expected callback error to be returned, got %v", err)
+	}
+}
+
+func TestStreamExportRejectsCorruptBinaryField(t *testing.T) {
+	dump := "__CURSOR=x\nMESSAGE\n\xff\xff\xff\xff\xff\xff\xff\xff"
+	err := StreamExport(strings.NewReader(dump), chop.Options{}, func(JournaldEvent) error { return nil })
+	if err == nil {
+		t.Error("expected error for an oversized binary field")
+	}
+}
+
+func TestDetect(t *testing.T) {
+	for _, name := range []string{"journal.export", "journal.json"} {
+		head, err := os.ReadFile(filepath.Join(testdataDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !Detect(head) {
+			t.Errorf("Detect should recognise %s", name)
+		}
+	}
+	for _, other := range []string{
+		"",
+		"Mar  1 10:00:01 host sshd[1]: Accepted password\n",
+		`{"level":"info","msg":"not a journal"}`,
+		"LPKSHHRH\x00\x00",
+	} {
+		if Detect([]byte(other)) {
+			t.Errorf("Detect(%q) should be false", other)
+		}
+	}
+}
+
+func TestSourceStreamsTextDump(t *testing.T) {
+	src, ok := chop.Lookup("journald")
+	if !ok {
+		t.Fatal("journald source should register itself with chop")
+	}
+	if _, ok := src.(chop.Detector); !ok {
+		t.Fatal("journald source should implement chop.Detector")
+	}
+	var events int
+	err := src.Stream(filepath.Join(testdataDir, "journal.json"), chop.Options{}, func(chop.Event) error {
+		events++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if events != 3 {
+		t.Errorf("expected 3 streamed events, got %d", events)
+	}
+
+	if isTextDump(t.TempDir()) {
+		t.Error("a directory is a binary journal location, not a text dump")
+	}
+	binary := filepath.Join(t.TempDir(), "system.journal")
+	if err := os.WriteFile(binary, append([]byte("LPKSHHRH"), bytes.Repeat([]byte{0}, 16)...), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if isTextDump(binary) {
+		t.Error("a file with the journal magic is not a text dump")
+	}
+}
@@ -0,0 +1,172 @@
+package journald
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// priorityNames are the syslog severities journald stores in PRIORITY,
+// indexed by value, as journalctl -p accepts them.
+var priorityNames = [...]string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// unitFields are the fields -unit matches, as journalctl -u does: the
+// unit's own processes log _SYSTEMD_UNIT, systemd logs UNIT when it starts
+// or stops the unit, and journald adds OBJECT_SYSTEMD_UNIT to what
+// privileged processes log about it.
+var unitFields = []string{"_SYSTEMD_UNIT", "UNIT", "OBJECT_SYSTEMD_UNIT"}
+
+// Filter holds the journal filters as given on the command line; the
+// journald source validates them and pushes them down into the systemd
+// journal API.
+type Filter struct {
+	// Units keeps entries from or about any of these systemd units.
+	Units []string
+	// Priority keeps entries at this priority or more severe, as a name
+	// ("warning") or number (0-7).
+	Priority string
+	// Boot keeps a single boot: an offset (0 current, -1 previous, 1 first)
+	// or a boot ID.
+	Boot string
+	// AfterCursor starts the scan after the entry with this cursor.
+	AfterCursor string
+}
+
+// IsZero reports whether no filter is set.
+func (f Filter) IsZero() bool {
+	return len(f.Units) == 0 && f.Priority == "" && f.Boot == "" && f.AfterCursor == ""
+}
+
+// filter is the parsed form of Filter. The live and binary
+// journal readers turn it into sdjournal matches; the text dump reader
+// applies it entry by entry.
+type filter struct {
+	units       []string
+	maxPriority int // -1 keeps every priority
+	bootID      string
+	bootOffset  int
+	byOffset    bool // bootOffset is set and must be resolved to a boot ID
+	afterCursor string
+}
+
+// parseFilter validates the -unit, -priority, -boot and -after-cursor values.
+func parseFilter(jf Filter) (filter, error) {
+	f := filter{maxPriority: -1, afterCursor: jf.AfterCursor}
+	for _, unit := range jf.Units {
+		// Like journalctl, a bare name refers to a service.
+		if !strings.Contains(unit, ".") {
+			unit += ".service"
+		}
+		f.units = append(f.units, unit)
+	}
+
+	if p := jf.Priority; p != "" {
+		for i, name := range priorityNames {
+			if p == name {
+				f.maxPriority = i
+			}
+		}
+		if n, err := strconv.Atoi(p); err == nil && n >= 0 && n < len(priorityNames) {
+			f.maxPriority = n
+		}
+		if f.maxPriority < 0 {
+			return f, fmt.Errorf("invalid -priority %q: want 0-7 or one of %s", p, strings.Join(priorityNames[:], ", "))
+		}
+	}
+
+	switch b := jf.Boot; {
+	case b == "":
+	case isBootID(b):
+		f.bootID = b
+	default:
+		n, err := strconv.Atoi(b)
+		if err != nil {
+			return f, fmt.Errorf("invalid -boot %q: want an offset like 0 or -1, or a 32-character boot ID", b)
+		}
+		f.bootOffset, f.byOffset = n, true
+	}
+	return f, nil
+}
+
+func isBootID(s string) bool {
+	if len(s) != 32 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !strings.ContainsRune("0123456789abcdef", rune(s[i])) {
+			return false
+		}
+	}
+	return true
+}
+
+// sdMatches returns the FIELD=value matches selecting the filtered entries
+// as clauses: an entry is selected when it has one match of every clause.
+// The reader ORs the matches of a clause with disjunctions and ANDs the
+// clauses with conjunctions. A boot offset must have been resolved into
+// bootID beforehand.
+func (f filter) sdMatches() [][]string {
+	var clauses [][]string
+	if len(f.units) > 0 {
+		var units []string
+		for _, field := range unitFields {
+			for _, unit := range f.units {
+				units = append(units, field+"="+unit)
+			}
+		}
+		clauses = append(clauses, units)
+	}
+	if f.maxPriority >= 0 {
+		var priorities []string
+		for p := 0; p <= f.maxPriority; p++ {
+			priorities = append(priorities, "PRIORITY="+strconv.Itoa(p))
+		}
+		clauses = append(clauses, priorities)
+	}
+	if f.bootID != "" {
+		clauses = append(clauses, []string{"_BOOT_ID=" + f.bootID})
+	}
+	return clauses
+}
+
+// matches reports whether an entry passes the unit, priority and boot ID
+// filters, mirroring what sdMatches selects.
+func (f filter) matches(fields map[string]string) bool {
+	if len(f.units) > 0 && !f.hasUnit(fields) {
+		return false
+	}
+	if f.maxPriority >= 0 {
+		p, err := strconv.Atoi(fields["PRIORITY"])
+		if err != nil || p > f.maxPriority {
+			return false
+		}
+	}
+	return f.bootID == "" || fields["_BOOT_ID"] == f.bootID
+}
+
+// hasUnit reports whether any of the unitFields of an entry names one of
+// the filtered units.
+func (f filter) hasUnit(fields map[string]string) bool {
+	for _, field := range unitFields {
+		for _, unit := range f.units {
+			if fields[field] == unit {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// bootIndex converts a -boot offset into an index into n boots ordered
+// oldest first: 0 is the last boot, -1 the one before, and positive values
+// count from the first boot, as with journalctl.
+func bootIndex(offset, n int) (int, error) {
+	i := offset - 1
+	if offset <= 0 {
+		i = n - 1 + offset
+	}
+	if i < 0 || i >= n {
+		return 0, fmt.Errorf("-boot %d is out of range: the journal holds %d boot(s)", offset, n)
+	}
+	return i, nil
+}
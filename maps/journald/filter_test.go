@@ -0,0 +1,123 @@
+package journald
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/M00NLIG7/ChopChopGo/maps/chop"
+)
+
+func TestParseFilter(t *testing.T) {
+	f, err := parseFilter(Filter{
+		Units:    []string{"sshd", "cron.service"},
+		Priority: "warning",
+		Boot:     "0123456789abcdef0123456789abcdef",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := [][]string{
+		{
+			"_SYSTEMD_UNIT=sshd.service", "_SYSTEMD_UNIT=cron.service",
+			"UNIT=sshd.service", "UNIT=cron.service",
+			"OBJECT_SYSTEMD_UNIT=sshd.service", "OBJECT_SYSTEMD_UNIT=cron.service",
+		},
+		{"PRIORITY=0", "PRIORITY=1", "PRIORITY=2", "PRIORITY=3", "PRIORITY=4"},
+		{"_BOOT_ID=0123456789abcdef0123456789abcdef"},
+	}
+	if got := f.sdMatches(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("sdMatches:\n got  %v\n want %v", got, want)
+	}
+
+	if f, err := parseFilter(Filter{Priority: "3", Boot: "-1"}); err != nil || f.maxPriority != 3 || !f.byOffset || f.bootOffset != -1 {
+		t.Errorf("numeric priority and boot offset: got %+v, %v", f, err)
+	}
+	if f, _ := parseFilter(Filter{}); len(f.sdMatches()) != 0 {
+		t.Errorf("an empty filter should add no matches, got %v", f.sdMatches())
+	}
+	for _, bad := range []Filter{{Priority: "loud"}, {Priority: "8"}, {Boot: "last"}} {
+		if _, err := parseFilter(bad); err == nil {
+			t.Errorf("parseFilter(%+v) should fail", bad)
+		}
+	}
+}
+
+func TestFilterMatches(t *testing.T) {
+	f, _ := parseFilter(Filter{Units: []string{"ssh"}, Priority: "info"})
+	cases := []struct {
+		fields map[string]string
+		want   bool
+	}{
+		{map[string]string{"_SYSTEMD_UNIT": "ssh.service", "PRIORITY": "6"}, true},
+		{map[string]string{"_SYSTEMD_UNIT": "ssh.service", "PRIORITY": "7"}, false},
+		{map[string]string{"_SYSTEMD_UNIT": "ssh.service"}, false},
+		{map[string]string{"_SYSTEMD_UNIT": "cron.service", "PRIORITY": "3"}, false},
+		{map[string]string{"_SYSTEMD_UNIT": "init.scope", "UNIT": "ssh.service", "PRIORITY": "6"}, true},
+		{map[string]string{"_SYSTEMD_UNIT": "session-3.scope", "OBJECT_SYSTEMD_UNIT": "ssh.service", "PRIORITY": "5"}, true},
+	}
+	for _, c := range cases {
+		if got := f.matches(c.fields); got != c.want {
+			t.Errorf("matches(%v) = %v, want %v", c.fields, got, c.want)
+		}
+	}
+}
+
+func TestBootIndex(t *testing.T) {
+	cases := []struct{ offset, want int }{{0, 2}, {-1, 1}, {-2, 0}, {1, 0}, {3, 2}}
+	for _, c := range cases {
+		if got, err := bootIndex(c.offset, 3); err != nil || got != c.want {
+			t.Errorf("bootIndex(%d, 3) = %d, %v; want %d", c.offset, got, err, c.want)
+		}
+	}
+	for _, offset := range []int{-3, 4} {
+		if _, err := bootIndex(offset, 3); err == nil {
+			t.Errorf("bootIndex(%d, 3) should be out of range", offset)
+		}
+	}
+}
+
+// journalOptions returns the options of a scan given the filters jf.
+func journalOptions(jf Filter) chop.Options {
+	var opts chop.Options
+	opts.SetSetting("journald", Config{Filter: jf})
+	return opts
+}
+
+func TestStreamExportFilters(t *testing.T) {
+	comms := func(jf Filter) ([]string, error) {
+		var got []string
+		err := StreamExportFile(filepath.Join(testdataDir, "journal.export"), journalOptions(jf), func(e JournaldEvent) error {
+			got = append(got, e.Fields["_COMM"])
+			return nil
+		})
+		return got, err
+	}
+	cases := []struct {
+		filter Filter
+		want   string
+	}{
+		{Filter{Units: []string{"cron"}}, "cron"},
+		{Filter{Priority: "info"}, "sshd"},
+		{Filter{Priority: "warning"}, ""},
+		{Filter{AfterCursor: "s=1;i=1"}, "bash,cron"},
+	}
+	for _, c := range cases {
+		got, err := comms(c.filter)
+		if err != nil {
+			t.Errorf("%+v: unexpected error: %v", c.filter, err)
+			continue
+		}
+		if strings.Join(got, ",") != c.want {
+			t.Errorf("%+v: got %v, want %q", c.filter, got, c.want)
+		}
+	}
+
+	if _, err := comms(Filter{AfterCursor: "s=9;i=9"}); err == nil {
+		t.Error("expected error for a cursor that is not in the dump")
+	}
+	if _, err := comms(Filter{Boot: "-1"}); err == nil {
+		t.Error("expected error for a boot offset on a text dump")
+	}
+}
@@ -4,160 +4,208 @@ package journald
 
 import (
 	"fmt"
-	"log"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
-	sigma "github.com/M00NLIG7/go-sigma-rule-engine"
-	"github.com/M00NLIG7/ChopChopGo/maps/mapping"
-	"github.com/M00NLIG7/ChopChopGo/maps/output"
+	"github.com/M00NLIG7/ChopChopGo/maps/chop"
+	"github.com/M00NLIG7/ChopChopGo/maps/input"
 	"github.com/coreos/go-systemd/v22/sdjournal"
-	"github.com/schollz/progressbar/v3"
 )
 
-// JournaldEvent represents a single entry from the systemd journal.
-type JournaldEvent struct {
-	Message   string
-	Timestamp string
-}
-
-// Keywords satisfies the sigma.Event interface.
-func (e JournaldEvent) Keywords() ([]string, bool) {
-	return []string{e.Message}, true
-}
-
-// Select satisfies the sigma.Event interface.
-func (e JournaldEvent) Select(name string) (interface{}, bool) {
-	switch name {
-	case "message":
-		return e.Message, true
-	case "timestamp":
-		return e.Timestamp, true
-	default:
-		return nil, false
+// readJournal walks a binary journal through the systemd API from the head:
+// the live system journal when paths is empty, otherwise a directory of
+// .journal files or any number of .journal files. Entries from several files
+// are interleaved in time order by the systemd API.
+//
+// The unit, priority and boot filters become sdjournal matches, so libsystemd
+// skips non-matching entries using its field indexes. The journal is ordered
+// by realtime, so the scan starts by seeking to -after-cursor or -since and
+// ends at the first entry after -until.
+//
+// With opts.Follow the walk does not end at the last entry: it waits for new
+// ones until opts.Stop is closed, starting at the tail of the journal unless
+// -after-cursor or -since gives an earlier starting point.
+//
+// With opts.State the walk resumes after the cursor checkpointed for paths,
+// unless -after-cursor is given, and checkpoints the last entry read.
+func readJournal(paths []string, opts chop.Options, fn func(JournaldEvent) error) error {
+	// last is the cursor of the last entry handed to fn.
+	var last string
+	jf := config(opts).Filter
+	if opts.State != nil {
+		path := strings.Join(paths, ", ")
+		if jf.AfterCursor == "" {
+			jf.AfterCursor = opts.State.Cursor(path)
+		}
+		defer func() {
+			if last != "" {
+				opts.State.SetCursor(path, last)
+			}
+		}()
 	}
-}
-
-// MappedJournaldEvent wraps a JournaldEvent with field-name translation so that
-// Sigma rules written with generic field names are resolved to journald-native
-// names before Select is called.
-type MappedJournaldEvent struct {
-	JournaldEvent
-	m *mapping.Mapping
-}
-
-func (e MappedJournaldEvent) Keywords() ([]string, bool) { return e.JournaldEvent.Keywords() }
-
-func (e MappedJournaldEvent) Select(name string) (interface{}, bool) {
-	return e.JournaldEvent.Select(e.m.Resolve(name))
-}
-
-// ParseEvents reads all entries from the live systemd journal.
-// Journald uses a binary format that requires the systemd API; reading from
-// an arbitrary file path is not supported.
-func ParseEvents() ([]JournaldEvent, error) {
-	j, err := sdjournal.NewJournal()
+	f, err := parseFilter(jf)
 	if err != nil {
-		return nil, fmt.Errorf("opening journal: %w", err)
+		return err
+	}
+	j, err := openJournal(paths)
+	if err != nil {
+		return err
 	}
 	defer j.Close()
 
-	if err := j.SeekHead(); err != nil {
-		return nil, fmt.Errorf("seeking journal head: %w", err)
+	if f.byOffset {
+		if f.bootID, err = bootID(j, f.bootOffset); err != nil {
+			return err
+		}
+	}
+	for i, clause := range f.sdMatches() {
+		if i > 0 {
+			if err := j.AddConjunction(); err != nil {
+				return fmt.Errorf("adding journal matches: %w", err)
+			}
+		}
+		for _, m := range clause {
+			if err := j.AddMatch(m); err != nil {
+				return fmt.Errorf("adding journal match %s: %w", m, err)
+			}
+			if err := j.AddDisjunction(); err != nil {
+				return fmt.Errorf("adding journal matches: %w", err)
+			}
+		}
 	}
 
-	var events []JournaldEvent
+	rng := opts.Range
+	switch {
+	case f.afterCursor != "":
+		err = j.SeekCursor(f.afterCursor)
+	case !rng.Since.IsZero():
+		err = j.SeekRealtimeUsec(uint64(rng.Since.UnixNano() / int64(time.Microsecond)))
+	case opts.Follow:
+		// Position on the last entry so that Next only yields new ones.
+		if err = j.SeekTail(); err == nil {
+			_, err = j.Previous()
+		}
+	default:
+		err = j.SeekHead()
+	}
+	if err != nil {
+		return fmt.Errorf("seeking journal: %w", err)
+	}
+
+	first := true
 	for {
 		n, err := j.Next()
 		if err != nil {
-			return nil, fmt.Errorf("reading journal entry: %w", err)
+			return fmt.Errorf("reading journal entry: %w", err)
 		}
 		if n == 0 {
-			break
+			if !opts.Follow {
+				return nil
+			}
+			select {
+			case <-opts.Stop:
+				return nil
+			default:
+			}
+			j.Wait(input.DefaultPoll)
+			continue
 		}
-
-		message, _ := j.GetData("MESSAGE")
-		// Strip the "MESSAGE=" prefix that sdjournal includes in the value.
-		message = strings.TrimPrefix(message, "MESSAGE=")
+		if first && f.afterCursor != "" && j.TestCursor(f.afterCursor) == nil {
+			// Seeking to a cursor lands on that entry; start after it.
+			first = false
+			continue
+		}
+		first = false
 
 		usec, err := j.GetRealtimeUsec()
 		if err != nil {
-			return nil, fmt.Errorf("reading entry timestamp: %w", err)
+			return fmt.Errorf("reading entry timestamp: %w", err)
+		}
+		if !rng.Until.IsZero() && usecTime(usec).After(rng.Until) {
+			return nil
+		}
+		if !rng.Since.IsZero() && usecTime(usec).Before(rng.Since) {
+			// Only possible after a cursor seek.
+			continue
 		}
-		ts := time.Unix(0, int64(usec)*int64(time.Microsecond)).UTC().Format(time.RFC3339)
 
-		events = append(events, JournaldEvent{
-			Message:   message,
-			Timestamp: ts,
-		})
+		entry, err := j.GetEntry()
+		if err != nil {
+			return fmt.Errorf("reading journal entry: %w", err)
+		}
+		if err := fn(newEvent(entry.Fields, usec)); err != nil {
+			return err
+		}
+		last = entry.Cursor
 	}
-	return events, nil
-}
-
-var journaldRenderer = output.Renderer{
-	Headers: []string{"Timestamp", "Message", "Tags", "Author"},
-	Row: func(r output.ScanResult) []string {
-		return []string{r.Timestamp, r.Message, output.TagString(r.Tags), r.Author}
-	},
 }
 
-// Chop scans the live systemd journal against Sigma rules and writes results
-// to stdout. Passing a file path is not supported because the journal uses a
-// binary format that requires the systemd API.
-// mappingPath overrides the default mappings/journald.yml when non-empty.
-func Chop(rulePath, outputType, mappingPath string) error {
-	events, err := ParseEvents()
+// bootID resolves a -boot offset by ordering the journal's boots by their
+// first entry.
+func bootID(j *sdjournal.Journal, offset int) (string, error) {
+	ids, err := j.GetUniqueValues("_BOOT_ID")
 	if err != nil {
-		return fmt.Errorf("reading journal: %w", err)
+		return "", fmt.Errorf("listing boots: %w", err)
 	}
-
-	ruleset, err := sigma.NewRuleset(sigma.Config{Directory: []string{rulePath}})
-	if err != nil {
-		return fmt.Errorf("loading ruleset: %w", err)
+	type boot struct {
+		id    string
+		first uint64
 	}
-
-	showProgress := outputType != "json" && outputType != "csv"
-	var bar *progressbar.ProgressBar
-	if showProgress {
-		bar = progressbar.Default(int64(len(events)))
+	var boots []boot
+	for _, id := range ids {
+		j.FlushMatches()
+		if err := j.AddMatch("_BOOT_ID=" + id); err != nil {
+			return "", fmt.Errorf("listing boots: %w", err)
+		}
+		if err := j.SeekHead(); err != nil {
+			return "", fmt.Errorf("listing boots: %w", err)
+		}
+		if n, err := j.Next(); err != nil || n == 0 {
+			continue
+		}
+		usec, err := j.GetRealtimeUsec()
+		if err != nil {
+			return "", fmt.Errorf("listing boots: %w", err)
+		}
+		boots = append(boots, boot{id, usec})
 	}
+	j.FlushMatches()
 
-	if mappingPath == "" {
-		mappingPath = "mappings/journald.yml"
+	sort.Slice(boots, func(a, b int) bool { return boots[a].first < boots[b].first })
+	i, err := bootIndex(offset, len(boots))
+	if err != nil {
+		return "", err
 	}
-	m := mapping.LoadOrIdentity(mappingPath, "journald")
+	return boots[i].id, nil
+}
 
-	var results []output.ScanResult
-	for _, event := range events {
-		mapped := MappedJournaldEvent{event, m}
-		if res, match := ruleset.EvalAll(mapped); match {
-			results = append(results, output.ScanResult{
-				Timestamp: event.Timestamp,
-				Message:   event.Message,
-				Tags:      res[0].Tags,
-				Author:    res[0].Author,
-				RuleID:    res[0].ID,
-				Title:     res[0].Title,
-			})
-		}
-		if showProgress {
-			bar.Add(1)
+// openJournal opens the journal readJournal reads from paths.
+func openJournal(paths []string) (*sdjournal.Journal, error) {
+	if len(paths) == 0 {
+		j, err := sdjournal.NewJournal()
+		if err != nil {
+			return nil, fmt.Errorf("opening journal: %w", err)
 		}
+		return j, nil
 	}
-
-	if err := output.Write(os.Stdout, outputType, results, journaldRenderer); err != nil {
-		return fmt.Errorf("writing output: %w", err)
-	}
-	if showProgress {
-		fmt.Printf("Processed %d journald events\n", len(events))
+	if len(paths) == 1 {
+		info, err := os.Stat(paths[0])
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			j, err := sdjournal.NewJournalFromDir(paths[0])
+			if err != nil {
+				return nil, fmt.Errorf("opening journal %s: %w", paths[0], err)
+			}
+			return j, nil
+		}
 	}
-	return nil
-}
-
-// ChopToLog is like Chop but calls log.Fatalf on error, for use from main.
-func ChopToLog(rulePath, outputType, mappingPath string) {
-	if err := Chop(rulePath, outputType, mappingPath); err != nil {
-		log.Fatalf("journald: %v", err)
+	j, err := sdjournal.NewJournalFromFiles(paths...)
+	if err != nil {
+		return nil, fmt.Errorf("opening journal %s: %w", strings.Join(paths, ", "), err)
 	}
+	return j, nil
 }
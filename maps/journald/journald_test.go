@@ -1,10 +1,13 @@
-//go:build linux
-
 package journald
 
 import (
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/M00NLIG7/ChopChopGo/maps/chop"
+	"github.com/M00NLIG7/ChopChopGo/maps/mapping"
 )
 
 func TestJournaldEventSelectMessage(t *testing.T) {
@@ -64,3 +67,96 @@ func TestJournaldEventKeywords(t *testing.T) {
 		t.Errorf("Keywords() should contain message content; got %v", keywords)
 	}
 }
+
+func TestJournaldEventSelectJournalFields(t *testing.T) {
+	e := newEvent(map[string]string{
+		"MESSAGE":           "Accepted publickey for root",
+		"_SYSTEMD_UNIT":     "ssh.service",
+		"_COMM":             "sshd",
+		"_EXE":              "/usr/sbin/sshd",
+		"_PID":              "812",
+		"_UID":              "0",
+		"SYSLOG_IDENTIFIER": "sshd",
+		"PRIORITY":          "6",
+		"_HOSTNAME":         "web01",
+	}, 1677664800000000)
+
+	if e.Message != "Accepted publickey for root" || e.Timestamp != "2023-03-01T10:00:00Z" {
+		t.Errorf("unexpected message/timestamp: %q %q", e.Message, e.Timestamp)
+	}
+	for name, want := range map[string]string{
+		"_SYSTEMD_UNIT":     "ssh.service",
+		"SYSLOG_IDENTIFIER": "sshd",
+		"PRIORITY":          "6",
+	} {
+		if v, ok := e.Select(name); !ok || v != want {
+			t.Errorf("Select(%q) = %v, %v; want %q", name, v, ok, want)
+		}
+	}
+
+	// The shipped mapping lets generic Sigma names reach the journal fields.
+	m := chop.NewMapped(e, mapping.LoadOrIdentity("../../mappings/journald.yml", "journald"))
+	for name, want := range map[string]string{
+		"Image":     "/usr/sbin/sshd",
+		"User":      "0",
+		"ProcessId": "812",
+		"Hostname":  "web01",
+	} {
+		if v, ok := m.Select(name); !ok || v != want {
+			t.Errorf("mapped Select(%q) = %v, %v; want %q", name, v, ok, want)
+		}
+	}
+
+	r := e.Result()
+	if r.Exe != "/usr/sbin/sshd" || r.PID != "812" || r.User != "0" || r.Host != "web01" {
+		t.Errorf("unexpected result columns: %+v", r)
+	}
+}
+
+func TestFindLog(t *testing.T) {
+	if path, err := (Source{}).FindLog(""); err != nil || path != "" {
+		t.Errorf("no -file should select the live journal, got %q, %v", path, err)
+	}
+
+	dir := t.TempDir()
+	if path, err := (Source{}).FindLog(dir); err != nil || path != dir {
+		t.Errorf("a journal directory should be accepted, got %q, %v", path, err)
+	}
+	if _, err := (Source{}).FindLog(filepath.Join(dir, "missing.journal")); err == nil {
+		t.Error("expected error for missing journal file")
+	}
+}
+
+func TestMergeable(t *testing.T) {
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "system.journal")
+	if err := os.WriteFile(binary, append(journalMagic, 0, 0, 0, 0), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]bool{
+		binary: true,
+		dir:    false,
+		filepath.Join(testdataDir, "journal.export"): false,
+		filepath.Join(dir, "missing.journal"):        false,
+	} {
+		if got := (Source{}).Mergeable(path); got != want {
+			t.Errorf("Mergeable(%s) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestHasField(t *testing.T) {
+	for name, want := range map[string]bool{
+		"_SYSTEMD_UNIT":     true,
+		"CODE_FUNC":         true,
+		"MY_APP_REQUEST_ID": true,
+		"Image":             false,
+		"message":           false,
+		"9LIVES":            false,
+		"":                  false,
+	} {
+		if got := (Source{}).HasField(name); got != want {
+			t.Errorf("HasField(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
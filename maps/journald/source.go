@@ -0,0 +1,201 @@
+package journald
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/M00NLIG7/ChopChopGo/maps/chop"
+	"github.com/M00NLIG7/ChopChopGo/maps/output"
+)
+
+// ParseEvents reads all entries from the journal at path; see StreamEvents.
+// It is a convenience wrapper around StreamEvents for callers that want the
+// whole journal in memory; scans go through Source.Stream instead.
+func ParseEvents(path string) ([]JournaldEvent, error) {
+	var events []JournaldEvent
+	err := StreamEvents(path, chop.Options{}, func(e JournaldEvent) error {
+		events = append(events, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// StreamEvents calls fn once per entry of the journal at path, in journal
+// order. A non-nil error from fn stops the walk and is returned unchanged.
+// path selects the journal:
+//
+//   - empty for the live system journal,
+//   - a directory of .journal files (such as a copied
+//     /var/log/journal/<machine-id>) or a single binary .journal file,
+//   - a text dump made with journalctl -o export or -o json, optionally
+//     compressed.
+//
+// The first two go through the systemd API and need Linux with libsystemd;
+// text dumps are parsed in pure Go on every platform. Only entries inside
+// opts.Range that pass the -unit, -priority, -boot and -after-cursor filters
+// are returned.
+func StreamEvents(path string, opts chop.Options, fn func(JournaldEvent) error) error {
+	if path != "" && isTextDump(path) {
+		return StreamExportFile(path, opts, fn)
+	}
+	var paths []string
+	if path != "" {
+		paths = []string{path}
+	}
+	return readJournal(paths, opts, fn)
+}
+
+// journalMagic starts every binary journal file.
+var journalMagic = []byte("LPKSHHRH")
+
+// isTextDump reports whether path is a regular file that is not a binary
+// journal, and so should be a journalctl export or JSON dump.
+func isTextDump(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		return false
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	head := make([]byte, len(journalMagic))
+	n, _ := io.ReadFull(f, head)
+	return !bytes.Equal(head[:n], journalMagic)
+}
+
+// Source plugs the systemd journal into the chop registry under the
+// "journald" target. Without -file the live journal is read; -file and
+// -journal-dir select collected .journal files, directories or journalctl
+// text dumps instead.
+type Source struct{}
+
+func init() { chop.Register(Source{}) }
+
+// Name satisfies the chop.Source interface.
+func (Source) Name() string { return "journald" }
+
+// Config holds the journald settings given on the command line.
+type Config struct {
+	// Filter narrows the scan with -unit, -priority, -boot and
+	// -after-cursor.
+	Filter Filter
+}
+
+// config returns the journald settings recorded in opts.
+func config(opts chop.Options) Config {
+	cfg, _ := opts.Setting("journald").(Config)
+	return cfg
+}
+
+// Flags satisfies the chop.Flagger interface. -journal-dir adds its
+// directory to the logs to scan.
+func (Source) Flags(fs *flag.FlagSet) func(*chop.Options, bool) error {
+	var cfg Config
+	var dir string
+	var units chop.StringList
+	fs.StringVar(&dir, "journal-dir", "", "journald only: scan the .journal files in this directory (e.g. a collected /var/log/journal) instead of the live journal")
+	fs.Var(&units, "unit", "journald only: keep entries from or about this systemd unit, by _SYSTEMD_UNIT, UNIT or OBJECT_SYSTEMD_UNIT like journalctl -u; repeatable and comma-separated (sshd is short for sshd.service)")
+	fs.StringVar(&cfg.Filter.Priority, "priority", "", "journald only: keep entries at this priority or more severe (emerg, alert, crit, err, warning, notice, info, debug or 0-7)")
+	fs.StringVar(&cfg.Filter.Boot, "boot", "", "journald only: keep a single boot, as an offset (0 current, -1 previous, 1 first) or a boot ID")
+	fs.StringVar(&cfg.Filter.AfterCursor, "after-cursor", "", "journald only: start after the entry with this cursor")
+	return func(opts *chop.Options, triage bool) error {
+		cfg.Filter.Units = units
+		if triage && (dir != "" || !cfg.Filter.IsZero()) {
+			return fmt.Errorf("-journal-dir, -unit, -priority, -boot and -after-cursor cannot be combined with -dir or -archive")
+		}
+		if _, err := parseFilter(cfg.Filter); err != nil {
+			return err
+		}
+		if dir != "" {
+			opts.Files = append(opts.Files, dir)
+		}
+		opts.SetSetting("journald", cfg)
+		return nil
+	}
+}
+
+// FindLog satisfies the chop.Source interface. An empty file selects the
+// live journal, for which the returned path is empty.
+func (Source) FindLog(file string) (string, error) {
+	if file == "" {
+		return "", nil
+	}
+	if _, err := os.Stat(file); err != nil {
+		return "", fmt.Errorf("failed to find provided journal file or directory %v", file)
+	}
+	return file, nil
+}
+
+// Stream satisfies the chop.Source interface.
+func (Source) Stream(path string, opts chop.Options, emit func(chop.Event) error) error {
+	return StreamEvents(path, opts, func(e JournaldEvent) error { return emit(e) })
+}
+
+// StreamReader satisfies the chop.Detector interface. Only the text dump
+// formats can be read from a stream.
+func (Source) StreamReader(r io.Reader, modTime time.Time, opts chop.Options, emit func(chop.Event) error) error {
+	return StreamExport(r, opts, func(e JournaldEvent) error { return emit(e) })
+}
+
+// Detect satisfies the chop.Detector interface.
+func (Source) Detect(head []byte) bool { return Detect(head) }
+
+// Fields satisfies the chop.Source interface. It lists the trusted and
+// well-known journal fields; any other field an entry carries can be
+// selected by name as well.
+func (Source) Fields() []string {
+	return []string{
+		"message", "timestamp",
+		"MESSAGE", "PRIORITY", "SYSLOG_FACILITY", "SYSLOG_IDENTIFIER", "SYSLOG_PID",
+		"_PID", "_UID", "_GID", "_COMM", "_EXE", "_CMDLINE", "_CAP_EFFECTIVE",
+		"_AUDIT_SESSION", "_AUDIT_LOGINUID", "_SYSTEMD_UNIT", "_SYSTEMD_USER_UNIT",
+		"_SYSTEMD_SLICE", "_BOOT_ID", "_MACHINE_ID", "_HOSTNAME", "_TRANSPORT",
+	}
+}
+
+// HasField satisfies the chop.OpenFields interface. Applications can attach
+// any field to a journal entry, so every valid journal field name is
+// accepted: uppercase letters, digits and underscores, not starting with a
+// digit.
+func (Source) HasField(name string) bool {
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// Mergeable satisfies the chop.Merger interface. Binary .journal files are
+// merged; directories are already read in time order and text dumps are
+// parsed one by one.
+func (Source) Mergeable(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir() && !isTextDump(path)
+}
+
+// StreamMerged satisfies the chop.Merger interface. The journal files at
+// paths are opened together, as the files of a -journal-dir are, so that
+// libsystemd interleaves their entries in time order.
+func (Source) StreamMerged(paths []string, opts chop.Options, emit func(chop.Event) error) error {
+	return readJournal(paths, opts, func(e JournaldEvent) error { return emit(e) })
+}
+
+// Renderer satisfies the chop.Source interface.
+func (Source) Renderer() output.Renderer { return journaldRenderer }
+
+// DefaultMapping satisfies the chop.Source interface.
+func (Source) DefaultMapping() string { return "mappings/journald.yml" }
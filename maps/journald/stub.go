@@ -4,17 +4,13 @@ package journald
 
 import (
 	"fmt"
-	"log"
-)
 
-// Chop is not supported on non-Linux platforms because journald is Linux-only.
-func Chop(rulePath, outputType, mappingPath string) error {
-	return fmt.Errorf("journald is not supported on this platform")
-}
+	"github.com/M00NLIG7/ChopChopGo/maps/chop"
+)
 
-// ChopToLog is like Chop but calls log.Fatalf on error, for use from main.
-func ChopToLog(rulePath, outputType, mappingPath string) {
-	if err := Chop(rulePath, outputType, mappingPath); err != nil {
-		log.Fatalf("journald: %v", err)
-	}
+// readJournal is not supported on non-Linux platforms: the live journal and
+// binary .journal files are only readable through libsystemd. Text dumps
+// made with journalctl -o export or -o json work everywhere.
+func readJournal(paths []string, opts chop.Options, fn func(JournaldEvent) error) error {
+	return fmt.Errorf("reading the live journal or binary .journal files is not supported on this platform; export the journal with journalctl -o export or -o json and pass that file with -file")
 }
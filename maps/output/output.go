@@ -11,17 +11,98 @@ import (
 )
 
 // ScanResult is the common result produced by all log mappers after evaluating Sigma rules.
+// Tags, Author, RuleID and Title describe the first matching rule; Matches
+// lists every rule that matched the event, including the first.
+// Ancestry and Login are only set when auditd events are correlated:
+// Ancestry is the chain of processes that led to the event, oldest first and
+// ending with the event's own, and Login is the login that opened its audit
+// session.
 type ScanResult struct {
 	Timestamp string   `json:"Timestamp"`
+	Host      string   `json:"Host,omitempty"`
 	Message   string   `json:"Message,omitempty"`
 	User      string   `json:"User,omitempty"`
 	Exe       string   `json:"Exe,omitempty"`
 	Terminal  string   `json:"Terminal,omitempty"`
 	PID       string   `json:"PID,omitempty"`
+	Ancestry  []string `json:"Ancestry,omitempty"`
+	Login     *Login   `json:"Login,omitempty"`
 	Tags      []string `json:"Tags"`
 	Author    string   `json:"Author"`
 	RuleID    string   `json:"ID"`
 	Title     string   `json:"Title"`
+	Matches   []Match  `json:"Matches,omitempty"`
+	File      string   `json:"File,omitempty"`
+	Target    string   `json:"Target,omitempty"`
+}
+
+// Login describes the login that opened an audit session.
+type Login struct {
+	Session  string `json:"Session"`
+	User     string `json:"User,omitempty"`
+	Addr     string `json:"Addr,omitempty"`
+	Terminal string `json:"Terminal,omitempty"`
+	Time     string `json:"Time,omitempty"`
+}
+
+// String formats l for a table or CSV cell, such as
+// "alice from 10.0.0.5 on /dev/pts/0 at 2024-03-01T08:00:00Z (ses 5)".
+func (l *Login) String() string {
+	if l == nil {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString(l.User)
+	for _, part := range [][2]string{{" from ", l.Addr}, {" on ", l.Terminal}, {" at ", l.Time}} {
+		if part[1] != "" && part[1] != "?" {
+			b.WriteString(part[0] + part[1])
+		}
+	}
+	fmt.Fprintf(&b, " (ses %s)", l.Session)
+	return strings.TrimSpace(b.String())
+}
+
+// Match identifies a single Sigma rule that fired on an event.
+type Match struct {
+	RuleID string   `json:"ID"`
+	Title  string   `json:"Title"`
+	Tags   []string `json:"Tags"`
+	Author string   `json:"Author"`
+}
+
+// SetMatches records every matching rule on r and copies the first one into
+// the top-level rule fields, which older consumers of the JSON output read.
+func (r *ScanResult) SetMatches(matches []Match) {
+	r.Matches = matches
+	if len(matches) == 0 {
+		return
+	}
+	r.RuleID = matches[0].RuleID
+	r.Title = matches[0].Title
+	r.Tags = matches[0].Tags
+	r.Author = matches[0].Author
+}
+
+// rows expands results into one entry per rule hit for the tabular formats,
+// so an event that matched three rules renders as three rows. Results without
+// a Matches list are passed through unchanged.
+func rows(results []ScanResult) []ScanResult {
+	out := make([]ScanResult, 0, len(results))
+	for _, res := range results {
+		if len(res.Matches) < 2 {
+			out = append(out, res)
+			continue
+		}
+		for _, m := range res.Matches {
+			row := res
+			row.RuleID = m.RuleID
+			row.Title = m.Title
+			row.Tags = m.Tags
+			row.Author = m.Author
+			out = append(out, row)
+		}
+	}
+	return out
 }
 
 // Renderer defines the table/CSV columns for a specific log type.
@@ -31,8 +112,57 @@ type Renderer struct {
 	Row     func(ScanResult) []string
 }
 
+// WithFile returns a copy of r with a leading File column, used when a scan
+// covers more than one log so that each row shows where it came from.
+func (r Renderer) WithFile() Renderer {
+	return Renderer{
+		Headers: append([]string{"File"}, r.Headers...),
+		Row: func(res ScanResult) []string {
+			return append([]string{res.File}, r.Row(res)...)
+		},
+	}
+}
+
+// WithContext returns a copy of r with trailing Ancestry and Login columns,
+// used when auditd events are correlated into process trees and sessions.
+func (r Renderer) WithContext() Renderer {
+	return Renderer{
+		Headers: append(append([]string{}, r.Headers...), "Ancestry", "Login"),
+		Row: func(res ScanResult) []string {
+			return append(r.Row(res), strings.Join(res.Ancestry, " → "), res.Login.String())
+		},
+	}
+}
+
+// TriageRenderer is used when one run mixes several log sources, e.g. a
+// directory or archive scan. It shows the columns every source can fill and
+// folds the source-specific ones into a single Details column.
+var TriageRenderer = Renderer{
+	Headers: []string{"File", "Target", "Timestamp", "Details", "Title", "Tags"},
+	Row: func(r ScanResult) []string {
+		return []string{r.File, r.Target, r.Timestamp, details(r), r.Title, TagString(r.Tags)}
+	},
+}
+
+// details summarises the source-specific columns of r: the message for
+// text logs, otherwise the process identity recorded by auditd.
+func details(r ScanResult) string {
+	if r.Message != "" {
+		return r.Message
+	}
+	var parts []string
+	for _, kv := range [][2]string{{"user", r.User}, {"exe", r.Exe}, {"pid", r.PID}, {"terminal", r.Terminal}} {
+		if kv[1] != "" {
+			parts = append(parts, kv[0]+"="+kv[1])
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
 // Write renders results in the requested format to w.
 // outputType must be "json", "csv", or any other value for a table.
+// JSON emits one object per event with all matches nested under Matches;
+// CSV and table emit one row per rule hit.
 func Write(w io.Writer, outputType string, results []ScanResult, r Renderer) error {
 	switch outputType {
 	case "json":
@@ -59,7 +189,7 @@ func writeCSV(w io.Writer, results []ScanResult, r Renderer) error {
 	if err := cw.Write(r.Headers); err != nil {
 		return fmt.Errorf("writing CSV header: %w", err)
 	}
-	for _, res := range results {
+	for _, res := range rows(results) {
 		if err := cw.Write(r.Row(res)); err != nil {
 			return fmt.Errorf("writing CSV row: %w", err)
 		}
@@ -71,7 +201,7 @@ func writeCSV(w io.Writer, results []ScanResult, r Renderer) error {
 func writeTable(w io.Writer, results []ScanResult, r Renderer) {
 	table := tablewriter.NewWriter(w)
 	table.SetHeader(r.Headers)
-	for _, res := range results {
+	for _, res := range rows(results) {
 		table.Append(r.Row(res))
 	}
 	table.Render()
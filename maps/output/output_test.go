@@ -129,3 +129,128 @@ func TestTagString(t *testing.T) {
 		t.Error("TagString of single element should return that element")
 	}
 }
+
+func multiMatchResults() []ScanResult {
+	r := ScanResult{Timestamp: "2023-01-01T00:00:00Z", Message: "sshd: bad login"}
+	r.SetMatches([]Match{
+		{RuleID: "generic-1", Title: "Generic Rule", Tags: []string{"attack.t1110"}, Author: "A"},
+		{RuleID: "specific-2", Title: "Specific Rule", Tags: []string{"attack.t1110.001"}, Author: "B"},
+	})
+	return []ScanResult{r}
+}
+
+func TestSetMatchesMirrorsFirstMatch(t *testing.T) {
+	r := multiMatchResults()[0]
+	if r.RuleID != "generic-1" || r.Title != "Generic Rule" || r.Author != "A" {
+		t.Errorf("top-level fields should mirror first match, got %+v", r)
+	}
+	if len(r.Matches) != 2 {
+		t.Errorf("expected 2 matches, got %d", len(r.Matches))
+	}
+}
+
+func TestWriteJSONAllMatches(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, "json", multiMatchResults(), testRenderer); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var out []ScanResult
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatalf("invalid JSON output: %v", err)
+	}
+	if len(out) != 1 {
+		t.Fatalf("JSON should keep one object per event, got %d", len(out))
+	}
+	if len(out[0].Matches) != 2 || out[0].Matches[1].RuleID != "specific-2" {
+		t.Errorf("JSON should carry every match, got %+v", out[0].Matches)
+	}
+}
+
+func TestWriteCSVOneRowPerMatch(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, "csv", multiMatchResults(), testRenderer); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected header + 2 rows, got %d lines", len(lines))
+	}
+	if !strings.Contains(lines[1], "Generic Rule") || !strings.Contains(lines[2], "Specific Rule") {
+		t.Errorf("rows should follow match order, got %q", lines[1:])
+	}
+	if !strings.Contains(lines[2], "attack.t1110.001") {
+		t.Errorf("second row should carry the second rule's tags, got %q", lines[2])
+	}
+}
+
+func TestRendererWithFile(t *testing.T) {
+	r := testRenderer.WithFile()
+	if r.Headers[0] != "File" || len(r.Headers) != len(testRenderer.Headers)+1 {
+		t.Errorf("unexpected headers %v", r.Headers)
+	}
+	row := r.Row(ScanResult{File: "/var/log/auth.log", Title: "T"})
+	if row[0] != "/var/log/auth.log" || row[len(row)-1] != "T" {
+		t.Errorf("unexpected row %v", row)
+	}
+}
+
+func TestRendererWithContext(t *testing.T) {
+	r := testRenderer.WithContext()
+	if n := len(r.Headers); n != len(testRenderer.Headers)+2 || r.Headers[n-2] != "Ancestry" || r.Headers[n-1] != "Login" {
+		t.Errorf("unexpected headers %v", r.Headers)
+	}
+	row := r.Row(ScanResult{
+		Ancestry: []string{"sshd", "bash", "curl"},
+		Login:    &Login{Session: "5", User: "alice", Addr: "10.0.0.5", Terminal: "/dev/pts/0"},
+	})
+	if got := row[len(row)-2]; got != "sshd → bash → curl" {
+		t.Errorf("ancestry cell: got %q", got)
+	}
+	if got := row[len(row)-1]; got != "alice from 10.0.0.5 on /dev/pts/0 (ses 5)" {
+		t.Errorf("login cell: got %q", got)
+	}
+	if row := r.Row(ScanResult{}); row[len(row)-2] != "" || row[len(row)-1] != "" {
+		t.Errorf("an uncorrelated result should leave the cells empty, got %v", row)
+	}
+}
+
+func TestStreamJSONLines(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewStream(&buf, "json", testRenderer)
+	for i := 0; i < 2; i++ {
+		if err := s.Write(sampleResults[0]); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d: %q", len(lines), buf.String())
+	}
+	for _, line := range lines {
+		var out ScanResult
+		if err := json.Unmarshal([]byte(line), &out); err != nil {
+			t.Fatalf("invalid JSON line %q: %v", line, err)
+		}
+		if out.RuleID != "abc-123" {
+			t.Errorf("expected RuleID abc-123, got %q", out.RuleID)
+		}
+	}
+}
+
+func TestStreamCSVHeaderOnce(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewStream(&buf, "csv", testRenderer)
+	for i := 0; i < 2; i++ {
+		if err := s.Write(sampleResults[0]); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		// Each row must be flushed as soon as it is written.
+		if got := strings.Count(buf.String(), "abc-123"); got != i+1 {
+			t.Fatalf("after write %d expected %d rows, got %d", i+1, i+1, got)
+		}
+	}
+	if got := strings.Count(buf.String(), "Timestamp,"); got != 1 {
+		t.Errorf("expected the header once, got %d times:\n%s", got, buf.String())
+	}
+}
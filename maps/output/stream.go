@@ -0,0 +1,66 @@
+package output
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Stream writes results one at a time as they are produced, for -follow
+// scans that never finish. JSON is written as one object per line (JSON
+// Lines), CSV as a header followed by a flushed row per rule hit, and the
+// table format as " | "-separated rows under a header line, since a bordered
+// table cannot be drawn before every row is known.
+type Stream struct {
+	w          io.Writer
+	outputType string
+	r          Renderer
+	csv        *csv.Writer
+	started    bool
+}
+
+// NewStream returns a Stream writing outputType to w with r's columns.
+func NewStream(w io.Writer, outputType string, r Renderer) *Stream {
+	s := &Stream{w: w, outputType: outputType, r: r}
+	if outputType == "csv" {
+		s.csv = csv.NewWriter(w)
+	}
+	return s
+}
+
+// Write emits res immediately.
+func (s *Stream) Write(res ScanResult) error {
+	if s.outputType == "json" {
+		if err := json.NewEncoder(s.w).Encode(res); err != nil {
+			return fmt.Errorf("encoding JSON: %w", err)
+		}
+		return nil
+	}
+
+	if !s.started {
+		s.started = true
+		if err := s.writeRow(s.r.Headers); err != nil {
+			return err
+		}
+	}
+	for _, row := range rows([]ScanResult{res}) {
+		if err := s.writeRow(s.r.Row(row)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Stream) writeRow(cols []string) error {
+	if s.csv != nil {
+		if err := s.csv.Write(cols); err != nil {
+			return fmt.Errorf("writing CSV row: %w", err)
+		}
+		s.csv.Flush()
+		return s.csv.Error()
+	}
+	_, err := fmt.Fprintln(s.w, strings.Join(cols, " | "))
+	return err
+}
@@ -0,0 +1,346 @@
+package rules
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	sigma "github.com/M00NLIG7/go-sigma-rule-engine"
+)
+
+// Correlation types, as named by Sigma v2.
+const (
+	eventCount      = "event_count"
+	valueCount      = "value_count"
+	temporal        = "temporal"
+	temporalOrdered = "temporal_ordered"
+)
+
+// correlationSpec is the correlation section of a Sigma v2 correlation rule.
+type correlationSpec struct {
+	Type      string         `yaml:"type"`
+	Rules     []string       `yaml:"rules"`
+	GroupBy   []string       `yaml:"group-by"`
+	Timespan  string         `yaml:"timespan"`
+	Condition map[string]any `yaml:"condition"`
+	Generate  bool           `yaml:"generate"`
+}
+
+// correlation tracks the matches of its input rules per group-by key over a
+// sliding window of event time and fires when its condition is met. After
+// firing, a group stays quiet for one window, so a long brute-force run is
+// reported once per window rather than once per attempt.
+type correlation struct {
+	result  sigma.Result
+	kind    string
+	inputs  []string
+	groupBy []string
+	// field is the field whose distinct values value_count counts.
+	field string
+	// span is the window length; zero means the whole scan.
+	span time.Duration
+	// threshold is exceeded when the count is above it, or reaches it when
+	// inclusive is set.
+	threshold int
+	inclusive bool
+
+	groups map[string]*group
+}
+
+type group struct {
+	hits []hit
+	// quietUntil suppresses new hits after the group fired.
+	quietUntil time.Time
+	fired      bool
+}
+
+type hit struct {
+	at    time.Time
+	input int
+	value string
+}
+
+// aggregationRe matches the Sigma v1 aggregation expression after the pipe.
+var aggregationRe = regexp.MustCompile(`^\s*(\w+)\(\s*([\w.\-]*)\s*\)\s*(?:by\s+([\w.\-]+(?:\s*,\s*[\w.\-]+)*))?\s*(>=|<=|==|=|>|<)\s*(\d+)\s*$`)
+
+// parseAggregation builds the correlation for a Sigma v1 rule whose condition
+// ends in an aggregation, which counts the rule's own matches.
+func parseAggregation(r sigma.Rule, expr string) (*correlation, error) {
+	// near joins selections within a timeframe rather than counting them.
+	if f := strings.Fields(expr); len(f) > 0 && f[0] == "near" {
+		return nil, ErrUnsupported{"near aggregation"}
+	}
+	m := aggregationRe.FindStringSubmatch(expr)
+	if m == nil {
+		return nil, fmt.Errorf("invalid aggregation %q", strings.TrimSpace(expr))
+	}
+	fn, field, by, op, n := m[1], m[2], m[3], m[4], m[5]
+	if fn != "count" {
+		return nil, ErrUnsupported{fmt.Sprintf("%s() aggregation", fn)}
+	}
+	c := &correlation{
+		result: ruleResult(r),
+		kind:   eventCount,
+		inputs: []string{r.ID},
+		field:  field,
+		groups: make(map[string]*group),
+	}
+	if field != "" {
+		c.kind = valueCount
+	}
+	for _, f := range strings.Split(by, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			c.groupBy = append(c.groupBy, f)
+		}
+	}
+	if tf, ok := r.Detection["timeframe"].(string); ok {
+		span, err := parseSpan(tf)
+		if err != nil {
+			return nil, err
+		}
+		c.span = span
+	}
+	threshold, _ := strconv.Atoi(n)
+	if err := c.setCondition(op, threshold); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// newCorrelation builds a Sigma v2 correlation rule. refs resolves the rule
+// names and IDs it references to the IDs their matches carry.
+func newCorrelation(doc document, refs map[string]string) (*correlation, error) {
+	spec := doc.Correlation
+	r := doc.Rule
+	if r.ID == "" {
+		r.ID = doc.Name
+	}
+	c := &correlation{
+		result:  ruleResult(r),
+		kind:    spec.Type,
+		groupBy: spec.GroupBy,
+		groups:  make(map[string]*group),
+	}
+	switch spec.Type {
+	case eventCount, valueCount, temporal, temporalOrdered:
+	default:
+		return nil, ErrUnsupported{fmt.Sprintf("correlation type %q", spec.Type)}
+	}
+	if len(spec.Rules) == 0 {
+		return nil, fmt.Errorf("correlation %q references no rules", r.Title)
+	}
+	for _, ref := range spec.Rules {
+		id, ok := refs[ref]
+		if !ok {
+			return nil, fmt.Errorf("correlation %q references unknown rule %q", r.Title, ref)
+		}
+		c.inputs = append(c.inputs, id)
+	}
+	if spec.Timespan != "" {
+		span, err := parseSpan(spec.Timespan)
+		if err != nil {
+			return nil, err
+		}
+		c.span = span
+	}
+
+	if c.kind == temporal || c.kind == temporalOrdered {
+		// Temporal correlations fire once every rule has matched.
+		c.threshold, c.inclusive = len(c.inputs), true
+		return c, nil
+	}
+	if f, ok := spec.Condition["field"].(string); ok {
+		c.field = f
+	}
+	if c.kind == valueCount && c.field == "" {
+		return nil, fmt.Errorf("value_count correlation %q has no condition field", r.Title)
+	}
+	ops := 0
+	for op, v := range spec.Condition {
+		if op == "field" {
+			continue
+		}
+		ops++
+		n, ok := v.(int)
+		if !ok || ops > 1 {
+			return nil, ErrUnsupported{fmt.Sprintf("correlation condition %v", spec.Condition)}
+		}
+		if err := c.setCondition(op, n); err != nil {
+			return nil, err
+		}
+	}
+	if ops == 0 {
+		return nil, fmt.Errorf("correlation %q has no condition", r.Title)
+	}
+	return c, nil
+}
+
+// setCondition applies a comparison. Only lower bounds can be decided while
+// events stream in; "fewer than N" needs the window to close first.
+func (c *correlation) setCondition(op string, n int) error {
+	switch op {
+	case ">", "gt":
+		c.threshold = n
+	case ">=", "gte":
+		c.threshold, c.inclusive = n, true
+	default:
+		return ErrUnsupported{fmt.Sprintf("%q comparison in aggregation", op)}
+	}
+	return nil
+}
+
+// ID is the rule ID reported when c fires.
+func (c *correlation) ID() string { return c.result.ID }
+
+// fields lists the event fields c reads.
+func (c *correlation) fields() []string {
+	fields := append([]string(nil), c.groupBy...)
+	if c.field != "" {
+		fields = append(fields, c.field)
+	}
+	return fields
+}
+
+func ruleResult(r sigma.Rule) sigma.Result {
+	return sigma.Result{ID: r.ID, Title: r.Title, Tags: r.Tags, Description: r.Description, Author: r.Author}
+}
+
+// observe records the event if one of its matches is an input of c and
+// reports whether that made c fire.
+func (c *correlation) observe(e sigma.Event, at time.Time, res sigma.Results) bool {
+	var inputs []int
+	for i, id := range c.inputs {
+		for _, r := range res {
+			if r.ID == id {
+				inputs = append(inputs, i)
+				break
+			}
+		}
+	}
+	if len(inputs) == 0 {
+		return false
+	}
+	if c.kind != temporal && c.kind != temporalOrdered {
+		// Counting correlations count each event once.
+		inputs = inputs[:1]
+	}
+
+	key := c.key(e)
+	g := c.groups[key]
+	if g == nil {
+		g = &group{}
+		c.groups[key] = g
+	}
+	if g.fired && (c.span == 0 || at.Before(g.quietUntil)) {
+		return false
+	}
+	g.fired = false
+	if n := len(g.hits); n > 0 && c.span > 0 && at.Before(g.hits[n-1].at.Add(-c.span)) {
+		// Time went backwards by more than a window, as when an older
+		// rotated log follows a newer one: start the group afresh.
+		g.hits = g.hits[:0]
+	}
+	value := c.value(e)
+	for _, input := range inputs {
+		g.hits = append(g.hits, hit{at: at, input: input, value: value})
+	}
+	if c.span > 0 {
+		c.expire(g, at)
+	}
+
+	if !c.met(g) {
+		return false
+	}
+	g.hits = g.hits[:0]
+	g.fired = true
+	g.quietUntil = at.Add(c.span)
+	return true
+}
+
+// expire drops the hits that fell out of the window ending at at.
+func (c *correlation) expire(g *group, at time.Time) {
+	start := at.Add(-c.span)
+	i := 0
+	for i < len(g.hits) && g.hits[i].at.Before(start) {
+		i++
+	}
+	g.hits = append(g.hits[:0], g.hits[i:]...)
+}
+
+// met reports whether the hits in g satisfy the condition.
+func (c *correlation) met(g *group) bool {
+	var n int
+	switch c.kind {
+	case eventCount:
+		n = len(g.hits)
+	case valueCount:
+		seen := make(map[string]bool)
+		for _, h := range g.hits {
+			if h.value != "" {
+				seen[h.value] = true
+			}
+		}
+		n = len(seen)
+	case temporal:
+		seen := make(map[int]bool)
+		for _, h := range g.hits {
+			seen[h.input] = true
+		}
+		n = len(seen)
+	case temporalOrdered:
+		// The longest prefix of inputs matched in order.
+		for _, h := range g.hits {
+			if n < len(c.inputs) && h.input == n {
+				n++
+			}
+		}
+	}
+	if c.inclusive {
+		return n >= c.threshold
+	}
+	return n > c.threshold
+}
+
+// key joins the group-by field values of e.
+func (c *correlation) key(e sigma.Event) string {
+	if len(c.groupBy) == 0 {
+		return ""
+	}
+	vals := make([]string, len(c.groupBy))
+	for i, f := range c.groupBy {
+		vals[i] = selectString(e, f)
+	}
+	return strings.Join(vals, "\x00")
+}
+
+func (c *correlation) value(e sigma.Event) string {
+	if c.field == "" {
+		return ""
+	}
+	return selectString(e, c.field)
+}
+
+func selectString(e sigma.Event, field string) string {
+	v, ok := e.Select(field)
+	if !ok || v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// sweep discards groups with nothing left in their window at time at.
+func (c *correlation) sweep(at time.Time) {
+	if c.span == 0 {
+		return
+	}
+	for key, g := range c.groups {
+		if at.Before(g.quietUntil) {
+			continue
+		}
+		if n := len(g.hits); n == 0 || g.hits[n-1].at.Before(at.Add(-c.span)) {
+			delete(c.groups, key)
+		}
+	}
+}
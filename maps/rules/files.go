@@ -0,0 +1,62 @@
+package rules
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ruleFiles resolves rule paths into the rule files to read. A directory
+// contributes every .yml file below it, a file is read as given whatever its
+// extension, and a pattern containing glob metacharacters is expanded and must
+// match something. Unlike sigma.NewRuleFileList, a missing path is an error
+// rather than a panic. Files reached through several paths are read once.
+func ruleFiles(paths []string) ([]string, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no rule paths given")
+	}
+	var files []string
+	seen := make(map[string]bool)
+	add := func(path string) {
+		if key := filepath.Clean(path); !seen[key] {
+			seen[key] = true
+			files = append(files, path)
+		}
+	}
+	for _, p := range paths {
+		matches := []string{p}
+		if strings.ContainsAny(p, "*?[") {
+			var err error
+			if matches, err = filepath.Glob(p); err != nil {
+				return nil, fmt.Errorf("invalid rule pattern %q: %w", p, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("rule pattern %q matched no files", p)
+			}
+		}
+		for _, m := range matches {
+			info, err := os.Stat(m)
+			if err != nil {
+				return nil, fmt.Errorf("rule path: %w", err)
+			}
+			if !info.IsDir() {
+				add(m)
+				continue
+			}
+			err = filepath.Walk(m, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if !info.IsDir() && strings.HasSuffix(path, ".yml") {
+					add(path)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, fmt.Errorf("reading rule directory %q: %w", m, err)
+			}
+		}
+	}
+	return files, nil
+}
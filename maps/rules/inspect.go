@@ -0,0 +1,91 @@
+package rules
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	sigma "github.com/M00NLIG7/go-sigma-rule-engine"
+)
+
+// Rule describes a loaded rule for diagnostics and rule selection.
+type Rule struct {
+	Path  string
+	ID    string
+	Title string
+	sigma.Logsource
+	// Fields lists the event fields the rule reads, without modifiers,
+	// including aggregation and correlation group-by fields.
+	Fields []string
+}
+
+// Problem is a rule that could not be loaded.
+type Problem struct {
+	Path  string
+	Title string
+	Err   error
+	// Unsupported is set for valid Sigma that this tool does not
+	// implement, as opposed to a broken rule.
+	Unsupported bool
+}
+
+// inspect walks a detection section, returning the fields it reads and an
+// error for the first modifier that Sigma does not define.
+func inspect(d sigma.Detection) ([]string, error) {
+	seen := make(map[string]bool)
+	var firstErr error
+	var walk func(v interface{})
+	addKey := func(key string, value interface{}) {
+		parts := strings.Split(key, "|")
+		seen[parts[0]] = true
+		for _, mod := range parts[1:] {
+			if mod == "fieldref" {
+				// The values name the fields compared against.
+				refs, ok := value.([]interface{})
+				if !ok {
+					refs = []interface{}{value}
+				}
+				for _, ref := range refs {
+					if ref, ok := ref.(string); ok {
+						seen[ref] = true
+					}
+				}
+			}
+			if !modifiers[mod] && firstErr == nil {
+				firstErr = fmt.Errorf("unknown modifier |%s on %s", mod, parts[0])
+			}
+		}
+	}
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[interface{}]interface{}:
+			for k, value := range v {
+				addKey(fmt.Sprint(k), value)
+			}
+		case map[string]interface{}:
+			for k, value := range v {
+				addKey(k, value)
+			}
+		case []interface{}:
+			for _, item := range v {
+				walk(item)
+			}
+		}
+	}
+	for name, v := range d {
+		if name == "condition" || name == "timeframe" {
+			continue
+		}
+		walk(v)
+	}
+	return sortedKeys(seen), firstErr
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
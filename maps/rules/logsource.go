@@ -0,0 +1,97 @@
+package rules
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	sigma "github.com/M00NLIG7/go-sigma-rule-engine"
+	"gopkg.in/yaml.v2"
+)
+
+// Table maps each target to the Sigma logsources its events can satisfy.
+// A rule applies to a target when every logsource field the rule sets
+// (product, category, service) equals, ignoring case, the same field of one
+// of the target's entries. Rules without a logsource apply everywhere, as
+// does an entry with no fields set. Targets missing from the table run every
+// rule.
+type Table map[string][]sigma.Logsource
+
+// linuxServices are the SigmaHQ linux services whose messages syslog and the
+// journal carry.
+var linuxServices = []string{
+	"syslog", "auth", "sshd", "sudo", "su", "cron", "guacamole", "vsftpd",
+	"clamav", "modsecurity",
+}
+
+// DefaultTable routes the SigmaHQ linux rules: auditd rules to the auditd
+// target together with the process_creation and file_event rules, whose
+// fields the auditd source assembles from EXECVE and PATH records, and the
+// syslog-borne services to syslog and journald.
+var DefaultTable = func() Table {
+	var syslog []sigma.Logsource
+	for _, svc := range linuxServices {
+		syslog = append(syslog, sigma.Logsource{Product: "linux", Service: svc})
+	}
+	return Table{
+		"auditd": {
+			{Product: "linux", Service: "auditd"},
+			{Product: "linux", Category: "process_creation"},
+			{Product: "linux", Category: "file_event"},
+		},
+		"syslog":   syslog,
+		"journald": syslog,
+	}
+}()
+
+// LoadTable reads a logsource table from a YAML file mapping target names to
+// lists of logsources, for example:
+//
+//	auditd:
+//	  - product: linux
+//	    service: auditd
+//	  - product: linux
+//	    category: network_connection
+//
+// Targets in the file replace their DefaultTable entries; other targets keep
+// the defaults.
+func LoadTable(path string) (Table, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading logsource table %q: %w", path, err)
+	}
+	var file Table
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing logsource table %q: %w", path, err)
+	}
+	t := make(Table, len(DefaultTable)+len(file))
+	for target, entries := range DefaultTable {
+		t[target] = entries
+	}
+	for target, entries := range file {
+		t[target] = entries
+	}
+	return t, nil
+}
+
+// Applies reports whether a rule with logsource ls should run on target.
+func (t Table) Applies(target string, ls sigma.Logsource) bool {
+	entries, ok := t[target]
+	if !ok || ls == (sigma.Logsource{Definition: ls.Definition}) {
+		return true
+	}
+	for _, e := range entries {
+		if e.Product == "" && e.Category == "" && e.Service == "" {
+			return true
+		}
+		if fieldMatches(ls.Product, e.Product) && fieldMatches(ls.Category, e.Category) && fieldMatches(ls.Service, e.Service) {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldMatches reports whether an entry satisfies a rule's logsource field.
+func fieldMatches(rule, entry string) bool {
+	return rule == "" || strings.EqualFold(rule, entry)
+}
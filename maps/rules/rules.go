@@ -0,0 +1,359 @@
+// Package rules loads Sigma rules for evaluation and adds what the underlying
+// engine lacks: aggregation conditions such as "count() by IpAddress > 10"
+// with a timeframe, and Sigma v2 correlation rules (event_count, value_count,
+// temporal and temporal_ordered).
+//
+// Single-event conditions are evaluated by go-sigma-rule-engine. A rule with
+// an aggregation is split at the pipe: the engine evaluates the selection on
+// the left, and the aggregation on the right is applied over time windows to
+// the events it matched. Correlation rules work the same way on the matches
+// of the rules they reference.
+package rules
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	sigma "github.com/M00NLIG7/go-sigma-rule-engine"
+	"gopkg.in/yaml.v2"
+)
+
+// Config selects the rules Load reads.
+type Config struct {
+	// Directory lists the rule directories, searched for .yml rules,
+	// individual rule files and glob patterns matching either.
+	Directory []string
+	// Logsources decides which rules run on which target; nil runs every
+	// rule on every target.
+	Logsources Table
+	// Placeholders holds the values the |expand modifier substitutes for
+	// each %name% placeholder. Placeholders without values match anything.
+	Placeholders map[string][]string
+}
+
+// Set is a loaded collection of rules. Eval is safe for concurrent use;
+// Correlate keeps the time-window state and must be called in event order.
+type Set struct {
+	trees []*sigma.Tree
+	// byTarget holds the trees that apply to each target of the logsource
+	// table.
+	byTarget   map[string][]*sigma.Tree
+	logsources Table
+	// hidden holds the IDs of rules whose matches only feed correlations
+	// and are not reported on their own.
+	hidden       map[string]bool
+	correlations []*correlation
+	observed     int
+	// predicates answers the pseudo-fields of rewritten selection keys.
+	predicates   map[string]predicate
+	placeholders map[string][]string
+
+	// Total is the number of rule files read; Ok, Failed and Unsupported
+	// count the rules in them, as sigma.Ruleset does.
+	Total, Ok, Failed, Unsupported int
+	// Rules describes every rule loaded and Problems every rule that was
+	// not, in file order.
+	Rules    []Rule
+	Problems []Problem
+}
+
+// document is one YAML document of a rule file: a detection rule or a
+// correlation rule.
+type document struct {
+	sigma.Rule `yaml:",inline"`
+	// Name is how correlation rules refer to other rules.
+	Name        string           `yaml:"name"`
+	Action      string           `yaml:"action"`
+	Correlation *correlationSpec `yaml:"correlation"`
+}
+
+// Load reads the rules that c.Directory names. Like sigma.NewRuleset, rules
+// that fail to parse or use unsupported features are counted and skipped
+// rather than failing the whole load. Files may hold several YAML documents,
+// as correlation rules are usually shipped together with the rules they use.
+func Load(c Config) (*Set, error) {
+	files, err := ruleFiles(c.Directory)
+	if err != nil {
+		return nil, err
+	}
+	s := &Set{
+		Total:        len(files),
+		hidden:       make(map[string]bool),
+		logsources:   c.Logsources,
+		predicates:   make(map[string]predicate),
+		placeholders: c.Placeholders,
+	}
+
+	var docs []document
+	var paths []string
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		d, err := decode(data)
+		if err != nil {
+			s.fail(path, "", err)
+			continue
+		}
+		for range d {
+			paths = append(paths, path)
+		}
+		docs = append(docs, d...)
+	}
+
+	// Correlations may reference rules in any file, so resolve them once
+	// every detection rule is known.
+	byRef := make(map[string]string)
+	var pending []int
+	for i, doc := range docs {
+		switch {
+		case doc.Action != "":
+			s.fail(paths[i], doc.Title, ErrUnsupported{"rule collections (action: " + doc.Action + ")"})
+		case doc.Correlation != nil:
+			pending = append(pending, i)
+		default:
+			id, err := s.addRule(doc, paths[i])
+			if err != nil {
+				s.fail(paths[i], doc.Title, err)
+				continue
+			}
+			byRef[id] = id
+			if doc.Name != "" {
+				byRef[doc.Name] = id
+			}
+		}
+	}
+	for _, i := range pending {
+		c, err := newCorrelation(docs[i], byRef)
+		if err != nil {
+			s.fail(paths[i], docs[i].Title, err)
+			continue
+		}
+		s.loaded(paths[i], docs[i].Rule, c.ID(), c.fields())
+		s.correlations = append(s.correlations, c)
+		if !docs[i].Correlation.Generate {
+			for _, id := range c.inputs {
+				s.hidden[id] = true
+			}
+		}
+	}
+
+	s.byTarget = make(map[string][]*sigma.Tree, len(c.Logsources))
+	for target := range c.Logsources {
+		trees := []*sigma.Tree{}
+		for _, tree := range s.trees {
+			if c.Logsources.Applies(target, tree.Rule.Logsource) {
+				trees = append(trees, tree)
+			}
+		}
+		s.byTarget[target] = trees
+	}
+	return s, nil
+}
+
+// Applies reports whether r runs on target under the logsource table.
+func (s *Set) Applies(target string, r Rule) bool {
+	return s.logsources.Applies(target, r.Logsource)
+}
+
+// decode splits a rule file into its YAML documents, skipping empty ones.
+func decode(data []byte) ([]document, error) {
+	var docs []document
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var doc document
+		err := dec.Decode(&doc)
+		if err == io.EOF {
+			return docs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if doc.Detection != nil || doc.Correlation != nil || doc.Action != "" {
+			docs = append(docs, doc)
+		}
+	}
+}
+
+// addRule builds the engine tree for a detection rule, splitting off an
+// aggregation if the condition has one, and returns the ID its matches
+// carry.
+func (s *Set) addRule(doc document, path string) (string, error) {
+	r := doc.Rule
+	if r.ID == "" {
+		// Matches are routed to aggregations and correlations by ID.
+		r.ID = doc.Name
+		if r.ID == "" {
+			r.ID = path
+		}
+	}
+	fields, err := inspect(r.Detection)
+	if err != nil {
+		return "", err
+	}
+	cond, _ := r.Detection["condition"].(string)
+	selection, agg, hasAgg := strings.Cut(cond, "|")
+
+	var c *correlation
+	if hasAgg {
+		var err error
+		if c, err = parseAggregation(r, agg); err != nil {
+			return "", err
+		}
+		// The engine must not see the pipe or the timeframe.
+		d := make(sigma.Detection, len(r.Detection))
+		for k, v := range r.Detection {
+			d[k] = v
+		}
+		d["condition"] = strings.TrimSpace(selection)
+		delete(d, "timeframe")
+		r.Detection = d
+	}
+
+	if r.Detection, err = s.rewrite(r.Detection); err != nil {
+		return "", err
+	}
+	tree, err := sigma.NewTree(sigma.RuleHandle{Rule: r, Path: path})
+	if err != nil {
+		return "", err
+	}
+	s.trees = append(s.trees, tree)
+	if c != nil {
+		s.correlations = append(s.correlations, c)
+		s.hidden[r.ID] = true
+		fields = append(fields, c.fields()...)
+	}
+	s.loaded(path, r, r.ID, fields)
+	return r.ID, nil
+}
+
+// loaded records a rule that was loaded.
+func (s *Set) loaded(path string, r sigma.Rule, id string, fields []string) {
+	s.Ok++
+	s.Rules = append(s.Rules, Rule{Path: path, ID: id, Title: r.Title, Logsource: r.Logsource, Fields: fields})
+}
+
+// fail records a rule that could not be loaded as failed or unsupported.
+func (s *Set) fail(path, title string, err error) {
+	p := Problem{Path: path, Title: title, Err: err}
+	var unsupported ErrUnsupported
+	switch {
+	case errors.As(err, &unsupported):
+		p.Unsupported = true
+	default:
+		switch err.(type) {
+		case sigma.ErrUnsupportedToken, *sigma.ErrUnsupportedToken:
+			p.Unsupported = true
+		}
+	}
+	if p.Unsupported {
+		s.Unsupported++
+	} else {
+		s.Failed++
+	}
+	s.Problems = append(s.Problems, p)
+}
+
+// ErrUnsupported reports a valid Sigma feature this package does not
+// implement, such as a sum() aggregation.
+type ErrUnsupported struct{ Msg string }
+
+func (e ErrUnsupported) Error() string { return "unsupported: " + e.Msg }
+
+// Records is implemented by events merged from several log records that
+// rules should also see one record at a time, such as an auditd event with
+// several PATH records. Records returns those views of the event.
+type Records interface {
+	Records() []sigma.Event
+}
+
+// Eval evaluates e, an event of target, against every single-event
+// condition that applies to target, including the selections behind
+// aggregations and correlations. A rule matches an event implementing
+// Records when it matches the event or any of its records. Pass the results
+// to Correlate before reporting them.
+func (s *Set) Eval(target string, e sigma.Event) (sigma.Results, bool) {
+	trees, ok := s.byTarget[target]
+	if !ok {
+		trees = s.trees
+	}
+	views := []sigma.Event{e}
+	if r, ok := e.(Records); ok {
+		views = append(views, r.Records()...)
+	}
+	if len(s.predicates) > 0 {
+		for i, v := range views {
+			views[i] = shimEvent{Event: v, predicates: s.predicates}
+		}
+	}
+	var results sigma.Results
+	for _, tree := range trees {
+		for _, v := range views {
+			if res, match := tree.Eval(v); match {
+				results = append(results, *res)
+				break
+			}
+		}
+	}
+	return results, len(results) > 0
+}
+
+// sweepEvery is how many Correlate calls pass between discarding groups
+// whose time window has expired.
+const sweepEvery = 4096
+
+// Correlate feeds the matches Eval found for e, which happened at at, to the
+// aggregations and correlations, and returns what should be reported: the
+// matches of rules that are not hidden, followed by every aggregation or
+// correlation that fired. Events without a timestamp (zero at) cannot be
+// placed in a time window and only pass through.
+//
+// Correlate must be called from one goroutine, for matching events in log
+// order.
+func (s *Set) Correlate(e sigma.Event, at time.Time, res sigma.Results) sigma.Results {
+	if len(s.correlations) == 0 {
+		return res
+	}
+	out := make(sigma.Results, 0, len(res))
+	for _, r := range res {
+		if !s.hidden[r.ID] {
+			out = append(out, r)
+		}
+	}
+	if at.IsZero() {
+		return out
+	}
+	for _, c := range s.correlations {
+		if c.observe(e, at, res) {
+			out = append(out, c.result)
+		}
+	}
+	if s.observed++; s.observed%sweepEvery == 0 {
+		for _, c := range s.correlations {
+			c.sweep(at)
+		}
+	}
+	return out
+}
+
+// parseSpan parses a Sigma timeframe or timespan: a number followed by s, m,
+// h or d.
+func parseSpan(v string) (time.Duration, error) {
+	v = strings.TrimSpace(v)
+	if len(v) < 2 {
+		return 0, fmt.Errorf("invalid timespan %q", v)
+	}
+	unit := map[byte]time.Duration{'s': time.Second, 'm': time.Minute, 'h': time.Hour, 'd': 24 * time.Hour}[v[len(v)-1]]
+	n, err := strconv.ParseInt(v[:len(v)-1], 10, 64)
+	if err != nil || unit == 0 || n <= 0 {
+		return 0, fmt.Errorf("invalid timespan %q", v)
+	}
+	return time.Duration(n) * unit, nil
+}
@@ -0,0 +1,505 @@
+package rules
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	sigma "github.com/M00NLIG7/go-sigma-rule-engine"
+)
+
+// fieldEvent is an event with named string fields.
+type fieldEvent map[string]string
+
+func (e fieldEvent) Keywords() ([]string, bool) { return []string{e["msg"]}, true }
+func (e fieldEvent) Select(name string) (interface{}, bool) {
+	v, ok := e[name]
+	return v, ok
+}
+
+func loadRules(t *testing.T, files map[string]string) *Set {
+	t.Helper()
+	dir := t.TempDir()
+	for name, body := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, err := Load(Config{Directory: []string{dir}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+var base = time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+// feed evaluates events spaced step apart and returns the IDs reported for
+// each, joined by "+", with "-" for events that reported nothing.
+func feed(s *Set, step time.Duration, events ...fieldEvent) string {
+	var out []string
+	for i, e := range events {
+		res, _ := s.Eval("test", e)
+		res = s.Correlate(e, base.Add(time.Duration(i)*step), res)
+		var ids []string
+		for _, r := range res {
+			ids = append(ids, r.ID)
+		}
+		if len(ids) == 0 {
+			ids = []string{"-"}
+		}
+		out = append(out, strings.Join(ids, "+"))
+	}
+	return strings.Join(out, " ")
+}
+
+func failed(ip, user string) fieldEvent {
+	return fieldEvent{"msg": "Failed password for " + user, "ip": ip, "user": user}
+}
+
+const bruteForce = `
+title: SSH Brute Force
+id: brute
+detection:
+  selection:
+    msg|contains: Failed password
+  timeframe: 1m
+  condition: selection | count() by ip > 2
+`
+
+func TestAggregationCount(t *testing.T) {
+	s := loadRules(t, map[string]string{"brute.yml": bruteForce})
+	if s.Ok != 1 {
+		t.Fatalf("expected the rule to load, got ok=%d failed=%d unsupported=%d", s.Ok, s.Failed, s.Unsupported)
+	}
+
+	got := feed(s, 10*time.Second,
+		failed("10.0.0.1", "root"),
+		failed("10.0.0.2", "root"),
+		failed("10.0.0.1", "root"),
+		failed("10.0.0.1", "root"),
+		failed("10.0.0.1", "root"),
+	)
+	// The third attempt from 10.0.0.1 fires; the group then stays quiet for
+	// a window instead of firing on every further attempt.
+	if want := "- - - brute -"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestAggregationWindowExpires(t *testing.T) {
+	s := loadRules(t, map[string]string{"brute.yml": bruteForce})
+	got := feed(s, 40*time.Second,
+		failed("10.0.0.1", "root"),
+		failed("10.0.0.1", "root"),
+		failed("10.0.0.1", "root"),
+		failed("10.0.0.1", "root"),
+	)
+	if want := "- - - -"; got != want {
+		t.Errorf("attempts spread over more than the timeframe fired: got %q", got)
+	}
+}
+
+func TestAggregationDistinctValues(t *testing.T) {
+	s := loadRules(t, map[string]string{"spray.yml": `
+title: Password Spraying
+id: spray
+detection:
+  selection:
+    msg|contains: Failed password
+  timeframe: 5m
+  condition: selection | count(user) by ip >= 3
+`})
+	got := feed(s, time.Second,
+		failed("10.0.0.1", "alice"),
+		failed("10.0.0.1", "alice"),
+		failed("10.0.0.1", "bob"),
+		failed("10.0.0.1", "carol"),
+	)
+	if want := "- - - spray"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+const correlated = `
+title: Failed Login
+id: failed-login
+name: failed_login
+detection:
+  selection:
+    msg|contains: Failed password
+  condition: selection
+---
+title: Many Failed Logins
+id: many-failed
+correlation:
+  type: event_count
+  rules:
+    - failed_login
+  group-by:
+    - ip
+  timespan: 1m
+  condition:
+    gte: 2
+`
+
+func TestCorrelationEventCount(t *testing.T) {
+	s := loadRules(t, map[string]string{"failed.yml": correlated})
+	if s.Ok != 2 {
+		t.Fatalf("expected both documents to load, got ok=%d failed=%d unsupported=%d", s.Ok, s.Failed, s.Unsupported)
+	}
+	got := feed(s, time.Second, failed("10.0.0.1", "root"), failed("10.0.0.1", "root"))
+	// The referenced rule only feeds the correlation.
+	if want := "- many-failed"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCorrelationGenerate(t *testing.T) {
+	s := loadRules(t, map[string]string{"failed.yml": correlated + "  generate: true\n"})
+	got := feed(s, time.Second, failed("10.0.0.1", "root"), failed("10.0.0.1", "root"))
+	if want := "failed-login failed-login+many-failed"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCorrelationTemporalOrdered(t *testing.T) {
+	s := loadRules(t, map[string]string{
+		"login.yml": `
+title: Login
+id: login
+detection:
+  selection:
+    msg|contains: Accepted
+  condition: selection
+`,
+		"sudo.yml": `
+title: Sudo
+id: sudo
+detection:
+  selection:
+    msg|contains: sudo
+  condition: selection
+---
+title: Login Then Sudo
+id: login-sudo
+correlation:
+  type: temporal_ordered
+  rules:
+    - login
+    - sudo
+  group-by:
+    - user
+  timespan: 10m
+`,
+	})
+	sudo := fieldEvent{"msg": "sudo: alice", "user": "alice"}
+	login := fieldEvent{"msg": "Accepted password", "user": "alice"}
+	if got, want := feed(s, time.Minute, sudo, login, sudo), "- - login-sudo"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestPlainRulesPassThrough(t *testing.T) {
+	s := loadRules(t, map[string]string{"plain.yml": `
+title: Failed
+id: plain
+detection:
+  selection:
+    msg|contains: Failed
+  condition: selection
+`})
+	// Without aggregations even untimed events are reported.
+	e := failed("10.0.0.1", "root")
+	res, _ := s.Eval("test", e)
+	if got := s.Correlate(e, time.Time{}, res); len(got) != 1 || got[0].ID != "plain" {
+		t.Errorf("expected the plain match, got %v", got)
+	}
+}
+
+func TestLoadCountsUnsupported(t *testing.T) {
+	s := loadRules(t, map[string]string{
+		"sum.yml": `
+title: Sum
+detection:
+  selection:
+    msg: x
+  condition: selection | sum(bytes) by ip > 100
+`,
+		"less.yml": `
+title: Less
+detection:
+  selection:
+    msg: x
+  condition: selection | count() < 3
+`,
+		"dangling.yml": `
+title: Dangling
+correlation:
+  type: event_count
+  rules:
+    - nowhere
+  condition:
+    gte: 2
+`,
+		"broken.yml": "title: [unterminated\n",
+	})
+	if s.Total != 4 || s.Ok != 0 || s.Unsupported != 2 || s.Failed != 2 {
+		t.Errorf("got total=%d ok=%d unsupported=%d failed=%d, want 4/0/2/2", s.Total, s.Ok, s.Unsupported, s.Failed)
+	}
+}
+
+func TestParseSpan(t *testing.T) {
+	for in, want := range map[string]time.Duration{"30s": 30 * time.Second, "5m": 5 * time.Minute, "2h": 2 * time.Hour, "1d": 24 * time.Hour} {
+		if got, err := parseSpan(in); err != nil || got != want {
+			t.Errorf("parseSpan(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "5", "m", "1.5h", "-1m", "3w"} {
+		if _, err := parseSpan(in); err == nil {
+			t.Errorf("parseSpan(%q) succeeded, want an error", in)
+		}
+	}
+}
+
+func TestLoadDiagnostics(t *testing.T) {
+	s := loadRules(t, map[string]string{
+		"fields.yml": bruteForce,
+		"sum.yml": `
+title: Volume
+detection:
+  sel:
+    msg: x
+  condition: sel | sum(bytes) by ip > 100
+`,
+		"near.yml": `
+title: Download and run
+detection:
+  download:
+    msg: wget
+  run:
+    msg: chmod
+  timeframe: 1m
+  condition: download | near run
+`,
+		"typo.yml": `
+title: Typo
+detection:
+  sel:
+    msg|contians: x
+  condition: sel
+`,
+	})
+	if len(s.Rules) != 1 || strings.Join(s.Rules[0].Fields, ",") != "msg,ip" {
+		t.Errorf("expected the brute-force rule to read msg and ip, got %+v", s.Rules)
+	}
+	problems := map[string]Problem{}
+	for _, p := range s.Problems {
+		problems[filepath.Base(p.Path)] = p
+	}
+	if p := problems["sum.yml"]; !p.Unsupported || !strings.Contains(p.Err.Error(), "sum()") {
+		t.Errorf("expected sum() to be unsupported, got %+v", p)
+	}
+	if p := problems["near.yml"]; !p.Unsupported || !strings.Contains(p.Err.Error(), "near") {
+		t.Errorf("expected near to be unsupported, got %+v", p)
+	}
+	if p := problems["typo.yml"]; p.Unsupported || p.Err == nil || p.Title != "Typo" {
+		t.Errorf("expected the misspelt modifier to fail, got %+v", p)
+	}
+}
+
+func TestTableApplies(t *testing.T) {
+	table := Table{
+		"auditd": {{Product: "linux", Service: "auditd"}},
+		"syslog": {{Product: "linux", Service: "syslog"}, {Product: "linux", Service: "auth"}},
+		"any":    {{}},
+	}
+	for _, tc := range []struct {
+		target string
+		ls     sigma.Logsource
+		want   bool
+	}{
+		{"auditd", sigma.Logsource{Product: "linux", Service: "auditd"}, true},
+		{"auditd", sigma.Logsource{Product: "Linux", Service: "AUDITD"}, true},
+		{"syslog", sigma.Logsource{Product: "linux", Service: "auditd"}, false},
+		{"syslog", sigma.Logsource{Product: "linux", Service: "auth"}, true},
+		// Fields the rule leaves unset do not restrict it.
+		{"syslog", sigma.Logsource{Product: "linux"}, true},
+		{"syslog", sigma.Logsource{Product: "linux", Category: "process_creation"}, false},
+		{"syslog", sigma.Logsource{Product: "windows", Service: "syslog"}, false},
+		{"syslog", sigma.Logsource{}, true},
+		{"any", sigma.Logsource{Product: "windows"}, true},
+		// Targets outside the table run everything.
+		{"other", sigma.Logsource{Product: "windows"}, true},
+	} {
+		if got := table.Applies(tc.target, tc.ls); got != tc.want {
+			t.Errorf("Applies(%s, %+v) = %v, want %v", tc.target, tc.ls, got, tc.want)
+		}
+	}
+}
+
+func TestDefaultTableRoutesAuditdCategories(t *testing.T) {
+	// The logsources of SigmaHQ's linux process_creation and file_event
+	// rules, which the auditd source provides the fields of.
+	for _, ls := range []sigma.Logsource{
+		{Product: "linux", Category: "process_creation"},
+		{Product: "linux", Category: "file_event"},
+	} {
+		if !DefaultTable.Applies("auditd", ls) {
+			t.Errorf("expected %+v to run on auditd", ls)
+		}
+		if DefaultTable.Applies("syslog", ls) {
+			t.Errorf("expected %+v not to run on syslog", ls)
+		}
+	}
+}
+
+func TestLoadTableOverridesTargets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logsources.yml")
+	if err := os.WriteFile(path, []byte("auditd:\n  - product: linux\n    category: process_creation\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	table, err := LoadTable(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !table.Applies("auditd", sigma.Logsource{Product: "linux", Category: "process_creation"}) {
+		t.Errorf("expected the file's auditd entry to apply")
+	}
+	if table.Applies("auditd", sigma.Logsource{Product: "linux", Service: "auditd"}) {
+		t.Errorf("expected the file to replace the default auditd entries")
+	}
+	if !table.Applies("syslog", sigma.Logsource{Product: "linux", Service: "sshd"}) {
+		t.Errorf("expected targets missing from the file to keep their defaults")
+	}
+}
+
+func TestEvalSelectsRulesByTarget(t *testing.T) {
+	dir := t.TempDir()
+	for name, service := range map[string]string{"audit.yml": "auditd", "auth.yml": "auth"} {
+		body := "title: " + service + "\nid: " + service + "\nlogsource:\n  product: linux\n  service: " + service +
+			"\ndetection:\n  sel:\n    msg|contains: Failed\n  condition: sel\n"
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, err := Load(Config{Directory: []string{dir}, Logsources: DefaultTable})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := failed("10.0.0.1", "root")
+	for target, want := range map[string]string{"auditd": "auditd", "syslog": "auth", "journald": "auth"} {
+		res, _ := s.Eval(target, e)
+		if len(res) != 1 || res[0].ID != want {
+			t.Errorf("%s: expected only the %s rule, got %v", target, want, res)
+		}
+	}
+}
+
+func TestLoadCombinesRulePaths(t *testing.T) {
+	root := t.TempDir()
+	rule := func(path, id string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		body := "title: " + id + "\nid: " + id + "\ndetection:\n  sel:\n    msg|contains: Failed\n  condition: sel\n"
+		if err := os.WriteFile(path, []byte(body), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rule(filepath.Join(root, "sigma", "a.yml"), "a")
+	rule(filepath.Join(root, "sigma", "sub", "b.yml"), "b")
+	rule(filepath.Join(root, "private", "c.yml"), "c")
+	rule(filepath.Join(root, "private", "d.yml"), "d")
+	rule(filepath.Join(root, "wip.yaml"), "wip")
+
+	s, err := Load(Config{Directory: []string{
+		filepath.Join(root, "sigma"),
+		filepath.Join(root, "private", "*.yml"),
+		filepath.Join(root, "wip.yaml"),
+		// Already covered by the directory above.
+		filepath.Join(root, "sigma", "a.yml"),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, r := range s.Rules {
+		ids = append(ids, r.ID)
+	}
+	if got := strings.Join(ids, " "); got != "a b c d wip" || s.Total != 5 {
+		t.Errorf("loaded %q from %d file(s), want a b c d wip from 5", got, s.Total)
+	}
+
+	for _, path := range []string{filepath.Join(root, "missing"), filepath.Join(root, "*.json")} {
+		if _, err := Load(Config{Directory: []string{path}}); err == nil {
+			t.Errorf("expected an error for %s", path)
+		}
+	}
+}
+
+// recordsEvent is a merged event with per-record views.
+type recordsEvent struct {
+	fieldEvent
+	records []fieldEvent
+}
+
+func (e recordsEvent) Records() []sigma.Event {
+	var records []sigma.Event
+	for _, r := range e.records {
+		records = append(records, r)
+	}
+	return records
+}
+
+func TestEvalMatchesRecords(t *testing.T) {
+	s := loadRules(t, map[string]string{
+		"create.yml": `
+title: Script Created
+id: create
+detection:
+  sel:
+    type: PATH
+    name|endswith: .sh
+    nametype: CREATE
+  condition: sel
+`,
+		"mixed.yml": `
+title: Parent Named Like A Script
+id: mixed
+detection:
+  sel:
+    name|endswith: .sh
+    nametype: PARENT
+  condition: sel
+`,
+		"merged.yml": `
+title: Touch
+id: merged
+detection:
+  sel:
+    exe: /usr/bin/touch
+  condition: sel
+`,
+	})
+	e := recordsEvent{
+		fieldEvent: fieldEvent{"type": "SYSCALL", "exe": "/usr/bin/touch", "name": "/tmp/", "nametype": "PARENT"},
+		records: []fieldEvent{
+			{"type": "PATH", "exe": "/usr/bin/touch", "name": "/tmp/", "nametype": "PARENT"},
+			{"type": "PATH", "exe": "/usr/bin/touch", "name": "/tmp/x.sh", "nametype": "CREATE"},
+		},
+	}
+	res, _ := s.Eval("test", e)
+	var ids []string
+	for _, r := range res {
+		ids = append(ids, r.ID)
+	}
+	sort.Strings(ids)
+	if got := strings.Join(ids, " "); got != "create merged" {
+		t.Errorf("matched %q, want each rule once and no mixing of records: create merged", got)
+	}
+}
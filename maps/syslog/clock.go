@@ -0,0 +1,118 @@
+package syslog
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// bsdLayout is the RFC3164 timestamp: no year, no zone, space-padded day.
+const bsdLayout = "Jan _2 15:04:05"
+
+// rolloverSlack is how far past the reference time a BSD timestamp may fall
+// before it is assumed to belong to the previous year. It absorbs clock skew
+// and logs copied shortly before their last line was written.
+const rolloverSlack = 24 * time.Hour
+
+// Clock turns syslog timestamps into RFC3339 so they sort and compare with
+// the other sources. BSD timestamps ("Mar  1 10:00:01") carry neither year nor
+// zone: the zone comes from Location and the year from Year or, when that is
+// zero, from ModTime — the first entry is placed in the latest year that does
+// not put it after the log was last written. Later entries advance the year
+// whenever the month wraps around (Dec → Jan). Feb 29 moves an inferred year
+// back to the nearest leap year until the log first wraps around; after that,
+// or in a Year that is not a leap year, it is an error.
+//
+// A Clock is stateful and must be used for a single log, in log order.
+type Clock struct {
+	// Year is the year of the first entry; zero infers it from ModTime.
+	Year int
+	// Location is the zone of BSD timestamps; nil means time.Local.
+	Location *time.Location
+	// ModTime is the log's modification time; zero means now.
+	ModTime time.Time
+
+	year      int
+	lastMonth time.Month
+	// rolled is set once the log has crossed New Year, from when on the
+	// year can no longer be moved back.
+	rolled bool
+}
+
+// errNotTimestamp is returned by Clock.Time for text that is in neither
+// timestamp format.
+var errNotTimestamp = errors.New("not a timestamp")
+
+// Normalize returns ts as an RFC3339 timestamp in UTC. Timestamps that cannot
+// be parsed or placed in a year are returned unchanged.
+func (c *Clock) Normalize(ts string) string {
+	t, err := c.Time(ts)
+	if err != nil {
+		return ts
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// Time parses ts, an ISO 8601 or BSD syslog timestamp, into an absolute time.
+// It fails with errNotTimestamp when ts is in neither format, and with
+// another error for a Feb 29 the clock's year cannot hold.
+func (c *Clock) Time(ts string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, ts); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(bsdLayout, ts)
+	if err != nil {
+		return time.Time{}, errNotTimestamp
+	}
+	return c.resolve(t)
+}
+
+// resolve places t, parsed without a year, in the right year and zone.
+func (c *Clock) resolve(t time.Time) (time.Time, error) {
+	loc := c.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	month := t.Month()
+	switch {
+	case c.year == 0 && c.Year != 0:
+		c.year = c.Year
+	case c.year == 0:
+		ref := c.ModTime
+		if ref.IsZero() {
+			ref = time.Now()
+		}
+		c.year = ref.Year()
+		if at(c.year, t, loc).After(ref.Add(rolloverSlack)) {
+			c.year--
+		}
+	case month < c.lastMonth-6:
+		// The month went backwards by more than half a year: the log has
+		// crossed New Year rather than merely being slightly out of order.
+		c.year++
+		c.rolled = true
+	}
+	if month == time.February && t.Day() == 29 && !isLeap(c.year) {
+		switch {
+		case c.Year != 0:
+			return time.Time{}, fmt.Errorf("Feb 29 does not exist in %d, the year given with -year", c.year)
+		case c.rolled:
+			return time.Time{}, fmt.Errorf("Feb 29 does not exist in %d; pass the year of the first entry with -year", c.year)
+		}
+		// The inferred year is a guess: the log must be from the latest
+		// leap year before it.
+		for !isLeap(c.year) {
+			c.year--
+		}
+	}
+	c.lastMonth = month
+	return at(c.year, t, loc), nil
+}
+
+func isLeap(year int) bool {
+	return year%4 == 0 && (year%100 != 0 || year%400 == 0)
+}
+
+func at(year int, t time.Time, loc *time.Location) time.Time {
+	return time.Date(year, t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, loc)
+}
@@ -0,0 +1,175 @@
+package syslog
+
+import (
+	"strconv"
+	"strings"
+)
+
+// facilityNames are the RFC 5424 facility codes, indexed by PRI >> 3, using
+// the usual syslog.conf keywords where one exists.
+var facilityNames = [...]string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "audit", "alert", "clock",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+// severityNames are the RFC 5424 severities, indexed by PRI & 7.
+var severityNames = [...]string{
+	"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug",
+}
+
+// nilValue is the RFC 5424 placeholder for an absent header field.
+const nilValue = "-"
+
+// parseLine parses one syslog line in any of the formats found on disk:
+//
+//   - RFC 5424: "<PRI>1 TIMESTAMP HOST APP PROCID MSGID [SD] MSG"
+//   - RFC 3164, optionally with <PRI>: "Mon DD HH:MM:SS HOST TAG[PID]: MSG"
+//   - rsyslog's high-precision file format, which is RFC 3164 with an ISO
+//     8601 timestamp
+//
+// The timestamp is returned as written. ok is false when the line has no
+// recognisable header.
+func parseLine(line string) (e SyslogEvent, ok bool) {
+	rest := line
+	if pri, n := parsePRI(rest); n > 0 {
+		e.Facility = facilityNames[pri>>3]
+		e.Severity = severityNames[pri&7]
+		rest = rest[n:]
+		if strings.HasPrefix(rest, "1 ") {
+			return parse5424(e, rest[2:])
+		}
+	}
+
+	ts, n := parseSyslogTimestamp(rest)
+	if ts == "" {
+		return e, false
+	}
+	e.Timestamp = ts
+	e.Hostname, rest = nextField(strings.TrimLeft(rest[n:], " "))
+	e.Program, e.PID, e.Message = parseTag(rest)
+	return e, true
+}
+
+// parsePRI parses a leading "<PRI>" and returns its value and length, or a
+// length of zero when the line does not start with a valid PRI (0–191).
+func parsePRI(s string) (pri, n int) {
+	if len(s) < 3 || s[0] != '<' {
+		return 0, 0
+	}
+	// At most three digits fit between the brackets.
+	head := s
+	if len(head) > 5 {
+		head = head[:5]
+	}
+	end := strings.IndexByte(head, '>')
+	if end < 2 {
+		return 0, 0
+	}
+	pri, err := strconv.Atoi(s[1:end])
+	if err != nil || pri < 0 || pri >= len(facilityNames)*8 {
+		return 0, 0
+	}
+	return pri, end + 1
+}
+
+// parse5424 parses the part of an RFC 5424 line after "<PRI>1 ".
+func parse5424(e SyslogEvent, s string) (SyslogEvent, bool) {
+	var header [5]string
+	for i := range header {
+		header[i], s = nextField(s)
+		if header[i] == nilValue {
+			header[i] = ""
+		}
+	}
+	e.Timestamp, e.Hostname, e.Program, e.PID, e.MsgID =
+		header[0], header[1], header[2], header[3], header[4]
+
+	if strings.HasPrefix(s, nilValue) {
+		s = s[len(nilValue):]
+	} else if sd, n, ok := parseStructuredData(s); ok {
+		e.StructuredData = sd
+		s = s[n:]
+	}
+	// The message may start with a UTF-8 byte order mark.
+	e.Message = strings.TrimPrefix(strings.TrimPrefix(s, " "), "\ufeff")
+	return e, true
+}
+
+// parseStructuredData parses the RFC 5424 SD-ELEMENTs at the start of s into
+// "SD-ID.PARAM-NAME" keys, e.g. "timeQuality.tzKnown". It returns the number
+// of bytes consumed, and ok is false when s does not start with a
+// well-formed element.
+func parseStructuredData(s string) (sd map[string]string, n int, ok bool) {
+	sd = make(map[string]string)
+	for n < len(s) && s[n] == '[' {
+		n++
+		idEnd := strings.IndexAny(s[n:], " ]")
+		if idEnd <= 0 {
+			return nil, 0, false
+		}
+		id := s[n : n+idEnd]
+		n += idEnd
+		for n < len(s) && s[n] == ' ' {
+			n++
+			eq := strings.IndexByte(s[n:], '=')
+			if eq <= 0 || n+eq+1 >= len(s) || s[n+eq+1] != '"' {
+				return nil, 0, false
+			}
+			name := s[n : n+eq]
+			n += eq + 2
+			value, used, ok := parseParamValue(s[n:])
+			if !ok {
+				return nil, 0, false
+			}
+			sd[id+"."+name] = value
+			n += used
+		}
+		if n >= len(s) || s[n] != ']' {
+			return nil, 0, false
+		}
+		n++
+	}
+	return sd, n, n > 0
+}
+
+// parseParamValue reads a PARAM-VALUE up to and including its closing quote,
+// undoing the \" \\ and \] escapes.
+func parseParamValue(s string) (value string, n int, ok bool) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"':
+			return b.String(), i + 1, true
+		case c == '\\' && i+1 < len(s) && strings.IndexByte(`"\]`, s[i+1]) >= 0:
+			i++
+			b.WriteByte(s[i])
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, false
+}
+
+// parseTag splits an RFC 3164 "TAG[PID]: MSG" into its parts. Lines whose
+// first word is not a tag (such as "-- MARK --") are returned whole as the
+// message.
+func parseTag(s string) (program, pid, msg string) {
+	tag, rest := nextField(s)
+	if len(tag) < 2 || !strings.HasSuffix(tag, ":") {
+		return "", "", s
+	}
+	tag = tag[:len(tag)-1]
+	if open := strings.IndexByte(tag, '['); open > 0 && strings.HasSuffix(tag, "]") {
+		return tag[:open], tag[open+1 : len(tag)-1], rest
+	}
+	return tag, "", rest
+}
+
+// nextField splits s at the first space.
+func nextField(s string) (field, rest string) {
+	if i := strings.IndexByte(s, ' '); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return s, ""
+}
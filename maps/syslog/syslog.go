@@ -2,15 +2,17 @@ package syslog
 
 import (
 	"bufio"
+	"bytes"
+	"flag"
 	"fmt"
-	"log"
+	"io"
 	"os"
 	"strings"
+	"time"
 
-	sigma "github.com/M00NLIG7/go-sigma-rule-engine"
-	"github.com/M00NLIG7/ChopChopGo/maps/mapping"
+	"github.com/M00NLIG7/ChopChopGo/maps/chop"
+	"github.com/M00NLIG7/ChopChopGo/maps/input"
 	"github.com/M00NLIG7/ChopChopGo/maps/output"
-	"github.com/schollz/progressbar/v3"
 )
 
 func isAlpha(b byte) bool { return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') }
@@ -49,88 +51,146 @@ func parseSyslogTimestamp(line string) (ts string, n int) {
 	return "", 0
 }
 
-// SyslogEvent represents a parsed syslog entry.
+// SyslogEvent represents a parsed syslog entry. Header fields the line does
+// not carry are left empty: on-disk RFC 3164 logs usually have no <PRI>, so
+// Facility and Severity are only set when the sender's priority was kept.
 type SyslogEvent struct {
-	Facility  string // hostname (closest available field without <PRI>)
-	Severity  string // not available in on-disk syslog format; kept for interface compat
-	Message   string // process[pid]: message text
-	Timestamp string
+	Facility       string // from <PRI>, e.g. "auth"
+	Severity       string // from <PRI>, e.g. "info"
+	Hostname       string
+	Program        string            // RFC 3164 tag or RFC 5424 APP-NAME, e.g. "sshd"
+	PID            string            // RFC 5424 PROCID or the [pid] of the tag
+	MsgID          string            // RFC 5424 only
+	StructuredData map[string]string // RFC 5424 only; see Select
+	Message        string            // message text after the header
+	Timestamp      string            // RFC3339 in UTC; see Clock
 }
 
 // Keywords satisfies the sigma.Event interface.
 func (e SyslogEvent) Keywords() ([]string, bool) {
-	return []string{e.Facility, e.Severity, e.Message}, true
+	return []string{e.Facility, e.Severity, e.Hostname, e.Program, e.Message}, true
 }
 
-// Select satisfies the sigma.Event interface.
+// Select satisfies the sigma.Event interface. RFC 5424 structured data is
+// selected as "SD-ID.PARAM-NAME", for example "origin.ip".
 func (e SyslogEvent) Select(name string) (interface{}, bool) {
 	switch name {
 	case "facility":
 		return e.Facility, true
 	case "severity":
 		return e.Severity, true
+	case "hostname":
+		return e.Hostname, true
+	case "program":
+		return e.Program, true
+	case "pid":
+		return e.PID, true
+	case "msgid":
+		return e.MsgID, true
 	case "message":
 		return e.Message, true
 	default:
+		if v, ok := e.StructuredData[name]; ok {
+			return v, true
+		}
 		return nil, false
 	}
 }
 
-// MappedSyslogEvent wraps a SyslogEvent with field-name translation so that
-// Sigma rules written with generic field names (e.g. Message, Hostname) are
-// resolved to syslog-native names before Select is called.
-type MappedSyslogEvent struct {
-	SyslogEvent
-	m *mapping.Mapping
-}
-
-func (e MappedSyslogEvent) Keywords() ([]string, bool) { return e.SyslogEvent.Keywords() }
-
-func (e MappedSyslogEvent) Select(name string) (interface{}, bool) {
-	return e.SyslogEvent.Select(e.m.Resolve(name))
+// ParseEvents reads a syslog file and returns the parsed events.
+// It is a convenience wrapper around StreamEvents for callers that want the
+// whole log in memory; the registered Source streams it to chop.Run instead.
+func ParseEvents(logFile string) ([]SyslogEvent, error) {
+	var events []SyslogEvent
+	err := StreamEvents(logFile, Clock{}, chop.TimeRange{}, func(e SyslogEvent) error {
+		events = append(events, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
 }
 
-// ParseEvents reads a syslog file and returns the parsed events.
+// StreamEvents reads a syslog file and calls fn once per parsed event in log
+// order. A non-nil error from fn stops the scan and is returned unchanged.
+// Rotated logs compressed with gzip, bzip2, xz or zstd are decompressed on
+// the fly; see input.Open.
 // Lines that do not match a recognised timestamp format are skipped rather than
 // causing an error, so mixed or partial logs are handled gracefully.
-func ParseEvents(logFile string) ([]SyslogEvent, error) {
-	file, err := os.Open(logFile)
+// Timestamps are normalized to RFC3339 by clock; when clock.ModTime is zero
+// the file's modification time is used. Events outside rng are skipped;
+// events without a usable timestamp are kept, since they cannot be placed.
+// A Feb 29 the clock cannot place in a year stops the stream with an error
+// naming its line.
+func StreamEvents(logFile string, clock Clock, rng chop.TimeRange, fn func(SyslogEvent) error) error {
+	file, err := input.Open(logFile)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer file.Close()
+	if clock.ModTime.IsZero() {
+		if info, err := os.Stat(logFile); err == nil {
+			clock.ModTime = info.ModTime()
+		}
+	}
+	return StreamReader(file, clock, rng, fn)
+}
 
-	var events []SyslogEvent
-	scanner := bufio.NewScanner(file)
+// StreamReader is StreamEvents for an already-open, decompressed log stream,
+// such as a member of a tar archive.
+func StreamReader(r io.Reader, clock Clock, rng chop.TimeRange, fn func(SyslogEvent) error) error {
+	scanner := bufio.NewScanner(r)
+	n := 0
 	for scanner.Scan() {
+		n++
 		line := scanner.Text()
 
-		timestamp, n := parseSyslogTimestamp(line)
-		if timestamp == "" {
+		event, ok := parseLine(line)
+		if !ok {
 			// Skip lines we cannot parse — don't abort the whole scan.
 			continue
 		}
+		// Every line goes through the clock, even out-of-range ones, so
+		// that year rollover is tracked across the whole log.
+		at, err := clock.Time(event.Timestamp)
+		switch {
+		case err == nil:
+			if !rng.Contains(at) {
+				continue
+			}
+			event.Timestamp = at.UTC().Format(time.RFC3339)
+		case err != errNotTimestamp:
+			return fmt.Errorf("line %d: %w", n, err)
+		}
 
-		// Everything after the timestamp is "hostname proc[pid]: message".
-		// We store the hostname in Facility and the rest in Message so that
-		// keyword-based Sigma rules can match against process/message content.
-		rest := strings.TrimSpace(line[n:])
-		var facility, message string
-		if idx := strings.IndexByte(rest, ' '); idx >= 0 {
-			facility = rest[:idx]
-			message = strings.TrimSpace(rest[idx+1:])
-		} else {
-			message = rest
+		if err := fn(event); err != nil {
+			return err
 		}
+	}
+	return scanner.Err()
+}
+
+// detectLines is how many non-empty lines Detect inspects before giving up.
+const detectLines = 5
 
-		events = append(events, SyslogEvent{
-			Facility:  facility,
-			Severity:  "",
-			Message:   message,
-			Timestamp: timestamp,
-		})
+// Detect reports whether head, the start of a decompressed log, looks like a
+// syslog file (including auth.log, kern.log and friends): one of its first
+// lines must have a header parseLine accepts.
+func Detect(head []byte) bool {
+	checked := 0
+	for _, line := range bytes.Split(head, []byte{'\n'}) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		if _, ok := parseLine(string(line)); ok {
+			return true
+		}
+		if checked++; checked >= detectLines {
+			break
+		}
 	}
-	return events, scanner.Err()
+	return false
 }
 
 // FindLog returns filePath when non-empty, otherwise falls back to the
@@ -152,71 +212,94 @@ func FindLog(file string) (string, error) {
 }
 
 var syslogRenderer = output.Renderer{
-	Headers: []string{"Timestamp", "Message", "Tags", "Author"},
+	Headers: []string{"Timestamp", "Host", "Program", "PID", "Message", "Title", "Tags", "Author"},
 	Row: func(r output.ScanResult) []string {
-		return []string{r.Timestamp, r.Message, output.TagString(r.Tags), r.Author}
+		return []string{r.Timestamp, r.Host, r.Exe, r.PID, r.Message, r.Title, output.TagString(r.Tags), r.Author}
 	},
 }
 
-// Chop scans the syslog against Sigma rules and writes results to stdout.
-// mappingPath overrides the default mappings/syslog.yml when non-empty.
-func Chop(rulePath, outputType, filePath, mappingPath string) error {
-	syslogPath, err := FindLog(filePath)
-	if err != nil {
-		return fmt.Errorf("finding syslog: %w", err)
+// Result satisfies the chop.Event interface. The program name is reported
+// in the Exe column.
+func (e SyslogEvent) Result() output.ScanResult {
+	return output.ScanResult{
+		Timestamp: e.Timestamp,
+		Host:      e.Hostname,
+		Exe:       e.Program,
+		PID:       e.PID,
+		Message:   e.Message,
 	}
+}
 
-	events, err := ParseEvents(syslogPath)
-	if err != nil {
-		return fmt.Errorf("parsing syslog: %w", err)
-	}
+// Source plugs syslog files into the chop registry under the "syslog" target.
+type Source struct{}
 
-	ruleset, err := sigma.NewRuleset(sigma.Config{Directory: []string{rulePath}})
-	if err != nil {
-		return fmt.Errorf("loading ruleset: %w", err)
-	}
+func init() { chop.Register(Source{}) }
 
-	showProgress := outputType != "json" && outputType != "csv"
-	var bar *progressbar.ProgressBar
-	if showProgress {
-		bar = progressbar.Default(int64(len(events)))
-	}
+// Name satisfies the chop.Source interface.
+func (Source) Name() string { return "syslog" }
+
+// FindLog satisfies the chop.Source interface.
+func (Source) FindLog(file string) (string, error) { return FindLog(file) }
 
-	if mappingPath == "" {
-		mappingPath = "mappings/syslog.yml"
+// Stream satisfies the chop.Source interface.
+// The log is opened with chop.OpenLog, so -follow and -state apply. BSD
+// timestamps of followed logs take the current year, since the file's
+// modification time keeps changing.
+func (Source) Stream(path string, opts chop.Options, emit func(chop.Event) error) error {
+	r, err := chop.OpenLog(path, opts)
+	if err != nil {
+		return err
 	}
-	m := mapping.LoadOrIdentity(mappingPath, "syslog")
-
-	var results []output.ScanResult
-	for _, event := range events {
-		mapped := MappedSyslogEvent{event, m}
-		if res, match := ruleset.EvalAll(mapped); match {
-			results = append(results, output.ScanResult{
-				Timestamp: event.Timestamp,
-				Message:   event.Message,
-				Tags:      res[0].Tags,
-				Author:    res[0].Author,
-				RuleID:    res[0].ID,
-				Title:     res[0].Title,
-			})
-		}
-		if showProgress {
-			bar.Add(1)
+	defer r.Close()
+	modTime := time.Now()
+	if !opts.Follow {
+		if info, err := os.Stat(path); err == nil {
+			modTime = info.ModTime()
 		}
 	}
+	return StreamReader(r, clockFor(opts, modTime), opts.Range, func(e SyslogEvent) error { return emit(e) })
+}
 
-	if err := output.Write(os.Stdout, outputType, results, syslogRenderer); err != nil {
-		return fmt.Errorf("writing output: %w", err)
-	}
-	if showProgress {
-		fmt.Printf("Processed %d syslog events\n", len(events))
-	}
-	return nil
+// StreamReader satisfies the chop.Detector interface.
+func (Source) StreamReader(r io.Reader, modTime time.Time, opts chop.Options, emit func(chop.Event) error) error {
+	return StreamReader(r, clockFor(opts, modTime), opts.Range, func(e SyslogEvent) error { return emit(e) })
+}
+
+// Config holds the syslog settings given on the command line.
+type Config struct {
+	// Year is the year of the first entry in logs whose timestamps omit it
+	// (BSD syslog). Zero infers it from each log's modification time.
+	Year int
 }
 
-// ChopToLog is like Chop but calls log.Fatalf on error, for use from main.
-func ChopToLog(rulePath, outputType, filePath, mappingPath string) {
-	if err := Chop(rulePath, outputType, filePath, mappingPath); err != nil {
-		log.Fatalf("syslog: %v", err)
+// Flags satisfies the chop.Flagger interface.
+func (Source) Flags(fs *flag.FlagSet) func(*chop.Options, bool) error {
+	var cfg Config
+	fs.IntVar(&cfg.Year, "year", 0, "syslog only: year of the first entry in logs whose timestamps omit it, as in BSD syslog (default: inferred from each file's modification time)")
+	return func(opts *chop.Options, triage bool) error {
+		opts.SetSetting("syslog", cfg)
+		return nil
 	}
 }
+
+// clockFor builds the timestamp clock for one log from the -year and -tz
+// settings.
+func clockFor(opts chop.Options, modTime time.Time) Clock {
+	cfg, _ := opts.Setting("syslog").(Config)
+	return Clock{Year: cfg.Year, Location: opts.Location, ModTime: modTime}
+}
+
+// Detect satisfies the chop.Detector interface.
+func (Source) Detect(head []byte) bool { return Detect(head) }
+
+// Fields satisfies the chop.Source interface. Structured-data fields are
+// named by the sender and so cannot be listed in advance.
+func (Source) Fields() []string {
+	return []string{"facility", "severity", "hostname", "program", "pid", "msgid", "message"}
+}
+
+// Renderer satisfies the chop.Source interface.
+func (Source) Renderer() output.Renderer { return syslogRenderer }
+
+// DefaultMapping satisfies the chop.Source interface.
+func (Source) DefaultMapping() string { return "mappings/syslog.yml" }
@@ -1,12 +1,19 @@
 package syslog
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/M00NLIG7/ChopChopGo/maps/chop"
+	"github.com/M00NLIG7/ChopChopGo/maps/mapping"
+	"github.com/M00NLIG7/ChopChopGo/maps/output"
 )
 
 const testdataDir = "../../testdata"
@@ -27,6 +34,16 @@ func TestParseEventsStandardFormat(t *testing.T) {
 	}
 }
 
+func TestParseEventsCompressed(t *testing.T) {
+	events, err := ParseEvents(filepath.Join(testdataDir, "syslog.log.bz2"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(events) != 4 {
+		t.Errorf("expected 4 events from bzip2 log, got %d", len(events))
+	}
+}
+
 func TestParseEventsRsyslogFormat(t *testing.T) {
 	events, err := ParseEvents(filepath.Join(testdataDir, "rsyslog.log"))
 	if err != nil {
@@ -41,6 +58,24 @@ func TestParseEventsRsyslogFormat(t *testing.T) {
 	}
 }
 
+func TestStreamEventsStopsOnCallbackError(t *testing.T) {
+	stop := errors.New("stop")
+	calls := 0
+	err := StreamEvents(filepath.Join(testdataDir, "syslog.log"), Clock{}, chop.TimeRange{}, func(SyslogEvent) error {
+		calls++
+		if calls == 2 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Errorf("expected callback error to be returned, got %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("expected stream to stop after 2 events, got %d calls", calls)
+	}
+}
+
 func TestParseEventsSkipsMalformedLines(t *testing.T) {
 	tmp := t.TempDir()
 	f := filepath.Join(tmp, "mixed.log")
@@ -92,8 +127,12 @@ func TestParseEventsMessageContent(t *testing.T) {
 	if len(events) != 1 {
 		t.Fatalf("expected 1 event, got %d", len(events))
 	}
-	if !strings.Contains(events[0].Message, "cron") {
-		t.Errorf("message should contain process info, got: %q", events[0].Message)
+	e := events[0]
+	if e.Hostname != "host" || e.Program != "cron" || e.PID != "5678" {
+		t.Errorf("header fields not split out: host=%q program=%q pid=%q", e.Hostname, e.Program, e.PID)
+	}
+	if e.Message != "(root) CMD (rm /var/log/syslog)" {
+		t.Errorf("message should hold only the text after the tag, got: %q", e.Message)
 	}
 }
 
@@ -263,3 +302,322 @@ func BenchmarkParseEventsRsyslog(b *testing.B) {
 		}
 	}
 }
+
+func TestSourceRegistered(t *testing.T) {
+	src, ok := chop.Lookup("syslog")
+	if !ok {
+		t.Fatal("syslog source should register itself with chop")
+	}
+	var events int
+	err := src.Stream(filepath.Join(testdataDir, "syslog.log"), chop.Options{}, func(chop.Event) error {
+		events++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if events != 4 {
+		t.Errorf("expected 4 streamed events, got %d", events)
+	}
+}
+
+func TestDetect(t *testing.T) {
+	for _, f := range []string{"syslog.log", "rsyslog.log"} {
+		head, err := os.ReadFile(filepath.Join(testdataDir, f))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !Detect(head) {
+			t.Errorf("Detect should recognise %s", f)
+		}
+	}
+	for _, notSyslog := range []string{
+		"",
+		"type=SYSCALL msg=audit(1364481363.243:24287): arch=c000003e\n",
+		"\x7fELF\x02\x01\x01",
+	} {
+		if Detect([]byte(notSyslog)) {
+			t.Errorf("Detect(%q) should be false", notSyslog)
+		}
+	}
+}
+
+func TestClockExplicitYearAndZone(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("time zone database unavailable: %v", err)
+	}
+	c := Clock{Year: 2022, Location: berlin}
+	if got, want := c.Normalize("Mar  1 10:00:01"), "2022-03-01T09:00:01Z"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestClockInfersYearFromModTime(t *testing.T) {
+	mod := time.Date(2024, time.March, 5, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		ts, want string
+	}{
+		{"Mar  1 10:00:01", "2024-03-01T10:00:01Z"},
+		// A date after the file was last written must be from last year.
+		{"Jun 10 08:00:00", "2023-06-10T08:00:00Z"},
+	}
+	for _, c := range cases {
+		clock := Clock{Location: time.UTC, ModTime: mod}
+		if got := clock.Normalize(c.ts); got != c.want {
+			t.Errorf("Normalize(%q) = %q, want %q", c.ts, got, c.want)
+		}
+	}
+}
+
+func TestClockRollsOverAtNewYear(t *testing.T) {
+	// A log written across New Year and last modified in January.
+	clock := Clock{Location: time.UTC, ModTime: time.Date(2024, time.January, 2, 0, 0, 0, 0, time.UTC)}
+	var got []string
+	for _, ts := range []string{"Dec 30 23:00:00", "Dec 31 23:59:59", "Jan  1 00:00:01", "Feb  3 12:00:00"} {
+		got = append(got, clock.Normalize(ts))
+	}
+	want := []string{
+		"2023-12-30T23:00:00Z",
+		"2023-12-31T23:59:59Z",
+		"2024-01-01T00:00:01Z",
+		"2024-02-03T12:00:00Z",
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	explicit := Clock{Year: 2019, Location: time.UTC}
+	explicit.Normalize("Dec 31 23:59:59")
+	if got := explicit.Normalize("Jan  1 00:00:00"); got != "2020-01-01T00:00:00Z" {
+		t.Errorf("explicit year should roll over too, got %q", got)
+	}
+}
+
+func TestClockPlacesLeapDayInLeapYear(t *testing.T) {
+	cases := []struct {
+		clock Clock
+		want  []string
+	}{
+		// Last written in 2023, so the first entry would be guessed 2023.
+		{Clock{Location: time.UTC, ModTime: time.Date(2023, time.June, 1, 0, 0, 0, 0, time.UTC)},
+			[]string{"2020-02-29T10:00:00Z", "2020-03-01T10:00:00Z"}},
+		// Last written before Feb 29 of a leap year.
+		{Clock{Location: time.UTC, ModTime: time.Date(2024, time.February, 10, 0, 0, 0, 0, time.UTC)},
+			[]string{"2020-02-29T10:00:00Z", "2020-03-01T10:00:00Z"}},
+		{Clock{Location: time.UTC, ModTime: time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC)},
+			[]string{"2024-02-29T10:00:00Z", "2024-03-01T10:00:00Z"}},
+	}
+	for _, c := range cases {
+		var got []string
+		for _, ts := range []string{"Feb 29 10:00:00", "Mar  1 10:00:00"} {
+			got = append(got, c.clock.Normalize(ts))
+		}
+		if strings.Join(got, ",") != strings.Join(c.want, ",") {
+			t.Errorf("got %v, want %v", got, c.want)
+		}
+	}
+}
+
+func TestClockRejectsLeapDayItCannotPlace(t *testing.T) {
+	// An explicit -year is kept rather than overridden.
+	explicit := Clock{Year: 2023, Location: time.UTC}
+	if _, err := explicit.Time("Feb 29 10:00:00"); err == nil {
+		t.Error("expected Feb 29 in -year 2023 to be an error")
+	}
+	if got := explicit.Normalize("Mar  1 10:00:00"); got != "2023-03-01T10:00:00Z" {
+		t.Errorf("the given year should stay, got %q", got)
+	}
+
+	// Once the log has crossed New Year the year no longer moves back.
+	clock := Clock{Location: time.UTC, ModTime: time.Date(2025, time.March, 5, 0, 0, 0, 0, time.UTC)}
+	var got []string
+	for _, ts := range []string{"Dec 30 10:00:00", "Jan  1 10:00:00", "Feb 29 10:00:00", "Mar  1 10:00:00"} {
+		got = append(got, clock.Normalize(ts))
+	}
+	want := []string{"2024-12-30T10:00:00Z", "2025-01-01T10:00:00Z", "Feb 29 10:00:00", "2025-03-01T10:00:00Z"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	err := StreamReader(strings.NewReader("Feb 29 10:00:00 host sshd[1]: Accepted password\n"),
+		Clock{Year: 2023, Location: time.UTC}, chop.TimeRange{}, func(SyslogEvent) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("expected a parse error for line 1, got %v", err)
+	}
+}
+
+func TestClockNormalizesISOTimestamps(t *testing.T) {
+	var c Clock
+	if got, want := c.Normalize("2023-03-01T12:00:01.123456+02:00"), "2023-03-01T10:00:01Z"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := c.Normalize("not a time"); got != "not a time" {
+		t.Errorf("unparseable timestamps should be kept verbatim, got %q", got)
+	}
+}
+
+func TestStreamUsesFileModTime(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "syslog")
+	if err := os.WriteFile(f, []byte("Dec 31 23:00:00 host cron[1]: late\nJan  1 01:00:00 host cron[1]: early\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	mod := time.Date(2021, time.January, 1, 2, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(f, mod, mod); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	err := Source{}.Stream(f, chop.Options{Location: time.UTC}, func(e chop.Event) error {
+		got = append(got, e.Result().Timestamp)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "2020-12-31T23:00:00Z,2021-01-01T01:00:00Z"
+	if strings.Join(got, ",") != want {
+		t.Errorf("got %v, want %s", got, want)
+	}
+}
+
+func TestParseEventsRFC5424(t *testing.T) {
+	events, err := ParseEvents(filepath.Join(testdataDir, "rfc5424.log"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(events) != 4 {
+		t.Fatalf("expected 4 events, got %d", len(events))
+	}
+
+	e := events[0]
+	want := SyslogEvent{
+		Facility:  "auth",
+		Severity:  "crit",
+		Hostname:  "mymachine.example.com",
+		Program:   "su",
+		MsgID:     "ID47",
+		Message:   "'su root' failed for lonvick on /dev/pts/8",
+		Timestamp: "2003-10-11T22:14:15Z",
+	}
+	if fmt.Sprintf("%+v", e) != fmt.Sprintf("%+v", want) {
+		t.Errorf("got  %+v\nwant %+v", e, want)
+	}
+
+	sd := events[1]
+	if sd.PID != "8710" || sd.Message != "An application event log entry..." {
+		t.Errorf("unexpected header or message: %+v", sd)
+	}
+	for name, value := range map[string]string{
+		"exampleSDID@32473.iut":         "3",
+		"exampleSDID@32473.eventSource": "Application",
+		"examplePriority@32473.class":   "high",
+		"origin.ip":                     `10.0.0.1 "edge]`,
+	} {
+		if v, ok := sd.Select(name); !ok || v != value {
+			t.Errorf("Select(%q) = %v, %v; want %q", name, v, ok, value)
+		}
+	}
+
+	if events[2].Message != "" || events[2].StructuredData["timeQuality.tzKnown"] != "1" {
+		t.Errorf("structured data without a message: %+v", events[2])
+	}
+	if events[3].Timestamp != "" || events[3].Hostname != "" || events[3].Message != "no header values" {
+		t.Errorf("nil header values should be empty: %+v", events[3])
+	}
+}
+
+func TestParseLineRFC3164(t *testing.T) {
+	cases := []struct {
+		line string
+		want SyslogEvent
+	}{
+		{
+			"<38>Mar  1 10:00:01 host sshd[1234]: Accepted password for root",
+			SyslogEvent{Facility: "auth", Severity: "info", Hostname: "host", Program: "sshd", PID: "1234",
+				Message: "Accepted password for root", Timestamp: "Mar  1 10:00:01"},
+		},
+		{
+			"Mar  3 11:15:30 server kernel: EXT4-fs error",
+			SyslogEvent{Hostname: "server", Program: "kernel", Message: "EXT4-fs error", Timestamp: "Mar  3 11:15:30"},
+		},
+		{
+			"2023-03-01T10:00:01.000000+00:00 box postfix/smtpd[7]: connect from unknown",
+			SyslogEvent{Hostname: "box", Program: "postfix/smtpd", PID: "7",
+				Message: "connect from unknown", Timestamp: "2023-03-01T10:00:01.000000+00:00"},
+		},
+		{
+			"Mar  3 11:15:30 server -- MARK --",
+			SyslogEvent{Hostname: "server", Message: "-- MARK --", Timestamp: "Mar  3 11:15:30"},
+		},
+	}
+	for _, c := range cases {
+		got, ok := parseLine(c.line)
+		if !ok {
+			t.Errorf("parseLine(%q) rejected the line", c.line)
+			continue
+		}
+		if fmt.Sprintf("%+v", got) != fmt.Sprintf("%+v", c.want) {
+			t.Errorf("parseLine(%q)\n got  %+v\n want %+v", c.line, got, c.want)
+		}
+	}
+
+	for _, bad := range []string{"<999>Mar  1 10:00:01 host x: y", "<13>not a header", "plain text"} {
+		if _, ok := parseLine(bad); ok {
+			t.Errorf("parseLine(%q) should be rejected", bad)
+		}
+	}
+}
+
+func TestSelectProgramThroughMapping(t *testing.T) {
+	e, _ := parseLine("Mar  1 10:00:01 host sshd[1234]: Accepted password for root")
+	m := chop.NewMapped(e, mapping.LoadOrIdentity("../../mappings/syslog.yml", "syslog"))
+	for field, want := range map[string]string{
+		"Image":     "sshd",
+		"ProcessId": "1234",
+		"Hostname":  "host",
+		"Message":   "Accepted password for root",
+	} {
+		if v, ok := m.Select(field); !ok || v != want {
+			t.Errorf("Select(%q) = %v, %v; want %q", field, v, ok, want)
+		}
+	}
+}
+
+func TestStreamEventsTimeRange(t *testing.T) {
+	rng := chop.TimeRange{
+		Since: time.Date(2023, 3, 2, 0, 0, 0, 0, time.UTC),
+		Until: time.Date(2023, 3, 2, 23, 59, 59, 0, time.UTC),
+	}
+	var got []string
+	err := StreamEvents(filepath.Join(testdataDir, "rsyslog.log"), Clock{}, rng, func(e SyslogEvent) error {
+		got = append(got, e.Timestamp)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(got, ",") != "2023-03-02T20:04:38Z" {
+		t.Errorf("expected only the 2 March event, got %v", got)
+	}
+}
+
+func TestRendererTellsMatchesApart(t *testing.T) {
+	// Two rules with the same tags and author hit one event; each row must
+	// say which rule it is for.
+	r := SyslogEvent{Timestamp: "2023-03-02T20:04:38Z", Program: "sshd", Message: "Failed password"}.Result()
+	r.SetMatches([]output.Match{
+		{RuleID: "1", Title: "SSH brute force", Tags: []string{"attack.t1110"}, Author: "a"},
+		{RuleID: "2", Title: "SSH password spraying", Tags: []string{"attack.t1110"}, Author: "a"},
+	})
+	var buf bytes.Buffer
+	if err := output.Write(&buf, "csv", []output.ScanResult{r}, syslogRenderer); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 || lines[1] == lines[2] {
+		t.Fatalf("expected two distinct rows, got %q", lines)
+	}
+	if !strings.Contains(lines[1], "SSH brute force") || !strings.Contains(lines[2], "SSH password spraying") {
+		t.Errorf("rows should name their rule, got %q", lines[1:])
+	}
+}
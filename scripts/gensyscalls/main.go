@@ -0,0 +1,266 @@
+// gensyscalls generates the syscall and errno name tables the auditd source
+// interprets syscall= and exit= fields with, from the Linux UAPI headers.
+//
+// Usage:
+//
+//	go run ./scripts/gensyscalls [flags]
+//
+// Flags:
+//
+//	-include string  directory holding the kernel headers (default: /usr/include)
+//	-out     string  file to write (default: maps/auditd/syscalls.go)
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ─── Architectures ───────────────────────────────────────────────────────────
+
+// arch describes one AUDIT_ARCH_* value and the header listing its syscalls.
+type arch struct {
+	audit  string // arch= as auditd logs it
+	name   string
+	header string // relative to -include
+	// defined lists the macros the architecture defines before including
+	// the header, which selects its conditional syscalls.
+	defined []string
+	bits    int
+}
+
+var arches = []arch{
+	{audit: "c000003e", name: "x86_64", header: "x86_64-linux-gnu/asm/unistd_64.h", bits: 64},
+	{audit: "40000003", name: "i386", header: "x86_64-linux-gnu/asm/unistd_32.h", bits: 32},
+	{audit: "c00000b7", name: "aarch64", header: "asm-generic/unistd.h", bits: 64, defined: []string{
+		"__ARCH_WANT_RENAMEAT",
+		"__ARCH_WANT_NEW_STAT",
+		"__ARCH_WANT_SET_GET_RLIMIT",
+		"__ARCH_WANT_TIME32_SYSCALLS",
+		"__ARCH_WANT_SYS_CLONE3",
+		"__ARCH_WANT_MEMFD_SECRET",
+	}},
+}
+
+var errnoHeaders = []string{"asm-generic/errno-base.h", "asm-generic/errno.h"}
+
+// ─── CLI ─────────────────────────────────────────────────────────────────────
+
+func main() {
+	include := flag.String("include", "/usr/include", "directory holding the kernel headers")
+	out := flag.String("out", filepath.Join("maps", "auditd", "syscalls.go"), "file to write")
+	flag.Parse()
+
+	src, err := generate(*include)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(*out, src, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing %s: %v\n", *out, err)
+		os.Exit(1)
+	}
+	fmt.Printf("wrote %s\n", *out)
+}
+
+// ─── Generation ──────────────────────────────────────────────────────────────
+
+func generate(include string) ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString("// Code generated by go run ./scripts/gensyscalls; DO NOT EDIT.\n\npackage auditd\n\n")
+
+	b.WriteString("// syscallNames maps an auditd arch= value to the names of its syscalls,\n// indexed by number.\n")
+	b.WriteString("var syscallNames = map[string][]string{\n")
+	for _, a := range arches {
+		names, err := readDefines(filepath.Join(include, a.header), "__NR", a)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&b, "\t%q: { // %s\n", a.audit, a.name)
+		writeTable(&b, names)
+		b.WriteString("\t},\n")
+	}
+	b.WriteString("}\n\n")
+
+	errnos := map[int]string{}
+	for _, h := range errnoHeaders {
+		names, err := readDefines(filepath.Join(include, h), "E", arch{bits: 64})
+		if err != nil {
+			return nil, err
+		}
+		for nr, name := range names {
+			errnos[nr] = name
+		}
+	}
+	b.WriteString("// errnoNames holds the names of the errno values, indexed by number.\n")
+	b.WriteString("var errnoNames = []string{\n")
+	writeTable(&b, errnos)
+	b.WriteString("}\n")
+
+	return format.Source(b.Bytes())
+}
+
+// writeTable writes the entries of an indexed []string literal in order.
+func writeTable(b *bytes.Buffer, names map[int]string) {
+	nrs := make([]int, 0, len(names))
+	for nr := range names {
+		nrs = append(nrs, nr)
+	}
+	sort.Ints(nrs)
+	for _, nr := range nrs {
+		fmt.Fprintf(b, "\t%d: %q,\n", nr, names[nr])
+	}
+}
+
+// readDefines returns the numeric "#define <prefix>name N" macros of a
+// header that are active for a, keyed by value. The syscall prefix __NR
+// also matches the generic header's __NR3264 names.
+func readDefines(path, prefix string, a arch) (map[int]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	defined := map[string]bool{}
+	for _, m := range a.defined {
+		defined[m] = true
+	}
+	names := map[int]string{}
+	// values holds the number of every macro defined so far, so that an
+	// alias such as __NR_fcntl __NR3264_fcntl renames its syscall.
+	values := map[string]int{}
+	// active holds, per open #if, whether its current branch is taken.
+	var active []bool
+	taken := func() bool {
+		for _, on := range active {
+			if !on {
+				return false
+			}
+		}
+		return true
+	}
+
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "#if":
+			on, err := condition(strings.Join(fields[1:], " "), defined, a.bits)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+			}
+			active = append(active, on)
+		case "#ifdef", "#ifndef":
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("%s:%d: %s without a macro", path, line, fields[0])
+			}
+			active = append(active, defined[fields[1]] == (fields[0] == "#ifdef"))
+		case "#else":
+			if len(active) == 0 {
+				return nil, fmt.Errorf("%s:%d: #else without #if", path, line)
+			}
+			active[len(active)-1] = !active[len(active)-1]
+		case "#endif":
+			if len(active) == 0 {
+				return nil, fmt.Errorf("%s:%d: #endif without #if", path, line)
+			}
+			active = active[:len(active)-1]
+		case "#define":
+			if len(fields) < 3 || !taken() {
+				continue
+			}
+			defined[fields[1]] = true
+			name, ok := defineName(fields[1], prefix)
+			if !ok {
+				continue
+			}
+			nr, err := strconv.Atoi(fields[2])
+			if err != nil {
+				alias, ok := values[fields[2]]
+				if !ok {
+					continue
+				}
+				nr = alias
+			}
+			values[fields[1]] = nr
+			names[nr] = name
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%s: no %s definitions found", path, prefix)
+	}
+	return names, nil
+}
+
+// defineName returns the syscall or errno name a macro defines.
+func defineName(macro, prefix string) (string, bool) {
+	if prefix == "E" {
+		return macro, strings.HasPrefix(macro, "E")
+	}
+	for _, p := range []string{"__NR3264_", "__NR_"} {
+		if strings.HasPrefix(macro, p) {
+			name := macro[len(p):]
+			// __NR_syscalls is the table size.
+			return name, name != "syscalls"
+		}
+	}
+	return "", false
+}
+
+// condition evaluates the #if expressions the UAPI headers use: defined()
+// tests and __BITS_PER_LONG comparisons, negated with ! and joined by && and
+// ||.
+func condition(expr string, defined map[string]bool, bits int) (bool, error) {
+	for _, clause := range strings.Split(expr, "||") {
+		on := true
+		for _, t := range strings.Split(clause, "&&") {
+			t, err := operand(strings.TrimSpace(t), defined, bits)
+			if err != nil {
+				return false, err
+			}
+			on = on && t
+		}
+		if on {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// operand evaluates a single operand of a condition.
+func operand(t string, defined map[string]bool, bits int) (bool, error) {
+	if strings.HasPrefix(t, "!") {
+		on, err := operand(strings.TrimSpace(t[1:]), defined, bits)
+		return !on, err
+	}
+	switch {
+	case strings.HasPrefix(t, "defined(") && strings.HasSuffix(t, ")"):
+		return defined[t[len("defined("):len(t)-1]], nil
+	case strings.HasPrefix(t, "defined "):
+		return defined[strings.TrimSpace(t[len("defined "):])], nil
+	case t == "__BITS_PER_LONG == 32":
+		return bits == 32, nil
+	case t == "__BITS_PER_LONG != 32":
+		return bits != 32, nil
+	case t == "__BITS_PER_LONG == 64":
+		return bits == 64, nil
+	}
+	return false, fmt.Errorf("unsupported condition %q", t)
+}